@@ -0,0 +1,175 @@
+package song2
+
+import (
+	"image"
+	"math"
+)
+
+// BilateralFilter smooths src while preserving edges. Each neighbor within
+// three sigmaSpace pixels is weighted by its distance and by its color
+// difference, with sigmaRange measured in 0-255 channel units. Its cost grows
+// with the square of sigmaSpace; see FastBilateralFilter for large radii.
+func BilateralFilter(src image.Image, sigmaSpace, sigmaRange float64) *image.RGBA {
+	clone := CloneToRGBA(src)
+	if sigmaSpace <= 0 || sigmaRange <= 0 {
+		return clone
+	}
+
+	bounds := clone.Bounds()
+	dst := image.NewRGBA(bounds)
+	width, height := bounds.Dx(), bounds.Dy()
+
+	rs := int(math.Ceil(sigmaSpace * 3))
+	spatial := make([]float64, (2*rs+1)*(2*rs+1))
+	for dy := -rs; dy <= rs; dy++ {
+		for dx := -rs; dx <= rs; dx++ {
+			spatial[(dy+rs)*(2*rs+1)+dx+rs] = math.Exp(-float64(dx*dx+dy*dy) / (2 * sigmaSpace * sigmaSpace))
+		}
+	}
+	rangeScale := -1 / (2 * sigmaRange * sigmaRange)
+
+	parallel(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				pos := y*clone.Stride + x*4
+				cr, cg, cb, ca := straight(clone.Pix[pos:])
+				if ca == 0 {
+					continue
+				}
+
+				var sr, sg, sb, sw float64
+				for dy := -rs; dy <= rs; dy++ {
+					row := clampInt(y+dy, 0, height-1) * clone.Stride
+					for dx := -rs; dx <= rs; dx++ {
+						npos := row + clampInt(x+dx, 0, width-1)*4
+						nr, ng, nb, na := straight(clone.Pix[npos:])
+						if na == 0 {
+							continue
+						}
+
+						d := (nr-cr)*(nr-cr) + (ng-cg)*(ng-cg) + (nb-cb)*(nb-cb)
+						w := spatial[(dy+rs)*(2*rs+1)+dx+rs] * math.Exp(d*rangeScale) * na
+						sr += w * nr
+						sg += w * ng
+						sb += w * nb
+						sw += w
+					}
+				}
+
+				a := ca * 255
+				setPremultiplied(dst.Pix[pos:], sr/sw*ca, sg/sw*ca, sb/sw*ca, a)
+			}
+		}
+	})
+
+	return dst
+}
+
+// FastBilateralFilter approximates BilateralFilter in time independent of
+// sigmaSpace. The luminance range is split into levels about sigmaRange
+// apart; for each level the colors are weighted by their closeness to it,
+// Gaussian blurred with the box passes and normalized, and every pixel
+// interpolates between the two levels nearest its own luminance.
+func FastBilateralFilter(src image.Image, sigmaSpace, sigmaRange float64) *image.RGBA {
+	clone := CloneToRGBA(src)
+	if sigmaSpace <= 0 || sigmaRange <= 0 {
+		return clone
+	}
+
+	bounds := clone.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	lum := newPlane(width, height)
+	minLum, maxLum := math.Inf(1), math.Inf(-1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, a := straight(clone.Pix[y*clone.Stride+x*4:])
+			if a == 0 {
+				continue
+			}
+			l := 0.299*r + 0.587*g + 0.114*b
+			lum.pix[y*width+x] = float32(l)
+			minLum = math.Min(minLum, l)
+			maxLum = math.Max(maxLum, l)
+		}
+	}
+	if minLum > maxLum {
+		return clone
+	}
+
+	levels := int(math.Ceil((maxLum-minLum)/sigmaRange)) + 1
+	spacing := (maxLum - minLum) / float64(levels-1)
+	if levels < 2 {
+		levels, spacing = 1, 1
+	}
+	rangeScale := -1 / (2 * sigmaRange * sigmaRange)
+
+	out := make([]float32, width*height*3)
+	ws := [4]*plane{}
+	for i := range ws {
+		ws[i] = newPlane(width, height)
+	}
+
+	for k := 0; k < levels; k++ {
+		level := minLum + float64(k)*spacing
+
+		parallel(height, func(start, end int) {
+			for y := start; y < end; y++ {
+				for x := 0; x < width; x++ {
+					i := y*width + x
+					pix := clone.Pix[y*clone.Stride+x*4:]
+					d := float64(lum.pix[i]) - level
+					w := math.Exp(d * d * rangeScale)
+					ws[0].pix[i] = float32(w * float64(pix[0]))
+					ws[1].pix[i] = float32(w * float64(pix[1]))
+					ws[2].pix[i] = float32(w * float64(pix[2]))
+					ws[3].pix[i] = float32(w * float64(pix[3]))
+				}
+			}
+		})
+
+		for _, p := range ws {
+			p.gaussianBlur(sigmaSpace, nil)
+		}
+
+		parallel(height, func(start, end int) {
+			for y := start; y < end; y++ {
+				for x := 0; x < width; x++ {
+					i := y*width + x
+					t := 1 - math.Abs(float64(lum.pix[i])-level)/spacing
+					if t <= 0 || ws[3].pix[i] <= 0 {
+						continue
+					}
+					for c := 0; c < 3; c++ {
+						out[i*3+c] += float32(t) * ws[c].pix[i] / ws[3].pix[i]
+					}
+				}
+			}
+		})
+	}
+
+	dst := image.NewRGBA(bounds)
+	parallel(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				pos := y*clone.Stride + x*4
+				a := float64(clone.Pix[pos+3])
+				setPremultiplied(dst.Pix[pos:],
+					float64(out[i*3+0])*a, float64(out[i*3+1])*a, float64(out[i*3+2])*a, a)
+			}
+		}
+	})
+
+	return dst
+}
+
+// straight returns the un-premultiplied color of the RGBA pixel at pix[0:4]
+// in 0-255 units, and its alpha from 0 to 1.
+func straight(pix []uint8) (r, g, b, a float64) {
+	if pix[3] == 0 {
+		return 0, 0, 0, 0
+	}
+	a = float64(pix[3]) / 255
+	return float64(pix[0]) / a, float64(pix[1]) / a, float64(pix[2]) / a, a
+}
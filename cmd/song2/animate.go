@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// blurGIF blurs every frame of an animated GIF with o. The frames are
+// composited onto the logical screen following their disposal methods, so
+// that each one is blurred as it is displayed, and are written back as full
+// frames with their own palette. Delays and loop count are kept. Cancelling
+// o stops before the next frame.
+func blurGIF(g *gif.GIF, o *filterOptions) (*gif.GIF, error) {
+	b := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if b.Empty() {
+		for _, f := range g.Image {
+			b = b.Union(f.Bounds())
+		}
+	}
+
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, len(g.Image)),
+		Delay:     g.Delay,
+		Disposal:  make([]byte, len(g.Image)),
+		LoopCount: g.LoopCount,
+		Config:    image.Config{Width: b.Dx(), Height: b.Dy()},
+	}
+
+	canvas := image.NewRGBA(b)
+	var previous *image.RGBA
+	for i, f := range g.Image {
+		if err := o.cancelled(); err != nil {
+			return nil, err
+		}
+
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, f.Bounds(), f, f.Bounds().Min, draw.Over)
+
+		// Each frame is its share of the progress of the whole animation.
+		fo := *o
+		if o.progress != nil {
+			n := len(g.Image)
+			fo.progress = func(done, total int) { o.progress(i*total+done, n*total) }
+		}
+
+		blurred, err := fo.filter(canvas)
+		if err != nil {
+			return nil, err
+		}
+		out.Image[i], out.Disposal[i] = palettedFrame(blurred)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, f.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return out, nil
+}
+
+// palettedFrame quantizes img to a median cut palette with Floyd-Steinberg
+// dithering, and returns the disposal method that shows it as a full frame:
+// a frame with transparent pixels must clear the previous one.
+func palettedFrame(img image.Image) (*image.Paletted, byte) {
+	b := img.Bounds()
+	p := medianCut{}.Quantize(make(color.Palette, 0, 256), img)
+	frame := image.NewPaletted(b, p)
+	draw.FloydSteinberg.Draw(frame, b, img, b.Min)
+
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return frame, gif.DisposalBackground
+		}
+	}
+	return frame, gif.DisposalNone
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Bounds())
+	copy(c.Pix, img.Pix)
+	return c
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is one input of a batch, with the directory it was found in relative
+// to the directory given on the command line.
+type job struct {
+	src string
+	dir string
+}
+
+// runBatch blurs every input of args into the -outdir directory with up to
+// -j files in flight, naming the outputs after the -name template.
+func runBatch(args []string) int {
+	jobs, err := collectInputs(args, *recursive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	if len(jobs) == 0 {
+		fmt.Fprintln(os.Stderr, "no input images")
+		return exitCodeErr
+	}
+
+	return runJobs(jobs)
+}
+
+// runJobs converts jobs concurrently and reports their errors.
+func runJobs(jobs []job) int {
+	workers := *jobsFlag
+	if workers < 1 {
+		workers = 1
+	}
+
+	var m *batchManifest
+	if *manifest != "" {
+		var err error
+		if m, err = openManifest(*manifest); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeErr
+		}
+		defer m.Close()
+	}
+	params := batchParams()
+
+	var (
+		mu      sync.Mutex
+		failed  int
+		skipped int
+		handled int
+		claimed = map[string]string{}
+	)
+
+	// With -progress, the bar counts files rather than the phases of one.
+	var files *progressBar
+	if *showBar {
+		files = &progressBar{}
+		files.setPhase("files", 0, 1)
+	}
+
+	// claim reserves dst for src. Two inputs such as a.png and a.jpg may map
+	// to the same output, and the second must not overwrite the first.
+	claim := func(dst, src string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if other, ok := claimed[dst]; ok {
+			return fmt.Errorf("output %s is also written for %s", dst, other)
+		}
+		claimed[dst] = src
+		return nil
+	}
+
+	// process converts j and, with a manifest, records how it went, read
+	// failures included. Skipped files keep their previous entry.
+	process := func(j job) (err error) {
+		e := manifestEntry{Input: j.src, Params: params}
+		skip := false
+		if m != nil {
+			defer func() {
+				if skip {
+					return
+				}
+				e.Time = time.Now().UTC()
+				if err != nil {
+					e.Error = err.Error()
+				}
+				if merr := m.record(e); merr != nil && err == nil {
+					err = merr
+				}
+			}()
+		}
+
+		data, err := readInput(j.src)
+		if err != nil {
+			return err
+		}
+
+		if m != nil {
+			e.InputSHA256 = sha256Hex(data)
+			if prev, ok := m.completed(j.src, e.InputSHA256, params); ok {
+				skip = true
+				mu.Lock()
+				skipped++
+				mu.Unlock()
+				return claim(prev.Output, j.src)
+			}
+		}
+
+		return convert(data, func(inputFormat string) (string, string, error) {
+			dst, f, err := batchTarget(j, inputFormat)
+			if err != nil {
+				return "", "", err
+			}
+			e.Output = dst
+			if err := claim(dst, j.src); err != nil {
+				return "", "", err
+			}
+			return dst, f, os.MkdirAll(filepath.Dir(dst), 0755)
+		})
+	}
+
+	ch := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				err := process(j)
+
+				mu.Lock()
+				if err != nil {
+					if files != nil {
+						fmt.Fprintln(os.Stderr)
+					}
+					fmt.Fprintf(os.Stderr, "%s: %v\n", j.src, err)
+					failed++
+				}
+				handled++
+				files.update(float64(handled) / float64(len(jobs)))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		ch <- j
+	}
+	close(ch)
+	wg.Wait()
+	files.finish()
+
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files already done\n", skipped, len(jobs))
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed\n", failed, len(jobs))
+		return exitCodeErr
+	}
+	return exitCodeOK
+}
+
+// batchTarget returns the output path and format of j. The extension given
+// to the template is that of -format or of the input format, but an explicit
+// extension in the template still chooses the format.
+func batchTarget(j job, inputFormat string) (string, string, error) {
+	f, err := outputFormat(*format, "", inputFormat)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Base(j.src)
+	file := strings.NewReplacer(
+		"{dir}", j.dir,
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{ext}", formatExts[f],
+	).Replace(*nameTmpl)
+	dst := filepath.Join(*outDir, file)
+	if samePath(dst, j.src) {
+		return "", "", fmt.Errorf("output would overwrite the input")
+	}
+
+	if f, err = outputFormat(*format, dst, inputFormat); err != nil {
+		return "", "", err
+	}
+	return dst, f, nil
+}
+
+// samePath reports whether a and b name the same file, through relative
+// paths, symbolic links or hard links.
+func samePath(a, b string) bool {
+	if ai, err := os.Stat(a); err == nil {
+		if bi, err := os.Stat(b); err == nil {
+			return os.SameFile(ai, bi)
+		}
+	}
+	aa, aerr := filepath.Abs(a)
+	ab, berr := filepath.Abs(b)
+	return aerr == nil && berr == nil && aa == ab
+}
+
+// collectInputs expands args into jobs. An argument is a file, a directory
+// whose images are walked if recursive is set, or a glob pattern, so that
+// patterns too long for the shell can be quoted.
+func collectInputs(args []string, recursive bool) ([]job, error) {
+	var jobs []job
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			matches, gerr := filepath.Glob(arg)
+			if gerr != nil || len(matches) == 0 {
+				return nil, err
+			}
+			more, err := collectInputs(matches, recursive)
+			if err != nil {
+				return nil, err
+			}
+			jobs = append(jobs, more...)
+			continue
+		}
+
+		if !info.IsDir() {
+			jobs = append(jobs, job{src: arg})
+			continue
+		}
+		if !recursive {
+			return nil, fmt.Errorf("%s is a directory, use -R to walk it", arg)
+		}
+
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || formatFromPath(path) == "" {
+				return nil
+			}
+			rel, err := filepath.Rel(arg, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, job{src: path, dir: rel})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSamePath(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "s.png")
+	writeTestPNG(t, src)
+	if err := os.Symlink(src, filepath.Join(dir, "link.png")); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{src, src, true},
+		{rel, src, true},
+		{filepath.Join(dir, ".", "sub", "..", "s.png"), src, true},
+		{filepath.Join(dir, "link.png"), src, true},
+		{filepath.Join(dir, "new.png"), src, false},
+		{filepath.Join(dir, "new.png"), filepath.Join(dir, "sub", "..", "new.png"), true},
+	}
+	for _, tt := range tests {
+		if got := samePath(tt.a, tt.b); got != tt.want {
+			t.Errorf("samePath(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheVersion is part of every cache key. Bump it when the output of a
+// filter changes, so that stale results are not reused.
+const cacheVersion = "song2 cache 1"
+
+// resultCache stores encoded outputs in a directory, named after the hash of
+// the input bytes and of every flag that changes the output. The least
+// recently used entries are evicted when the cache grows over maxSize.
+type resultCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// openCache creates dir if needed and indexes the entries already in it.
+func openCache(dir string, maxSize int64) (*resultCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &resultCache{dir: dir, maxSize: maxSize, entries: map[string]cacheEntry{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Only dir/xx/key files are entries, so that foreign files are never
+		// counted or evicted.
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if d.IsDir() {
+			if rel != "." && (len(parts) != 1 || !isHex(d.Name(), 2)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(parts) != 2 || !isHex(parts[1], sha256.Size*2) || !strings.HasPrefix(parts[1], parts[0]) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c.entries[d.Name()] = cacheEntry{info.Size(), info.ModTime()}
+		c.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// isHex reports whether s is n lowercase hexadecimal digits, as in the names
+// of cache entries and of their directories.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey returns the key of the output of data in outFormat with the
+// current flags.
+func cacheKey(data []byte, outFormat string) string {
+	params := flagValues("o", "outdir", "name", "R", "j", "manifest", "cache-dir", "cache-size", "no-cache", "progress")
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	sum := sha256.Sum256(data)
+	h.Write([]byte(cacheVersion + "\n" + hex.EncodeToString(sum[:]) + "\n" + outFormat + "\n"))
+	for _, name := range names {
+		h.Write([]byte(name + "=" + params[name] + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the output stored under key, if any, and marks it as used.
+func (c *resultCache) get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	// The entry may have been written by another process since openCache,
+	// or rewritten with another size, so its size is counted again.
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.size
+	}
+	c.entries[key] = cacheEntry{int64(len(b)), now}
+	c.size += int64(len(b))
+	c.evict()
+	return b, true
+}
+
+// put stores b under key and evicts the least recently used entries if the
+// cache is over its size. The file is written under a temporary name and
+// renamed, so that a concurrent reader never sees it half written.
+func (c *resultCache) put(key string, b []byte) error {
+	if int64(len(b)) > c.maxSize {
+		return nil
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.size
+	}
+	c.entries[key] = cacheEntry{int64(len(b)), time.Now()}
+	c.size += int64(len(b))
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxSize. c.mu must be held.
+func (c *resultCache) evict() {
+	if c.size <= c.maxSize {
+		return
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].used.Before(c.entries[keys[j]].used)
+	})
+
+	for _, key := range keys {
+		if c.size <= c.maxSize {
+			break
+		}
+		os.Remove(c.path(key))
+		c.size -= c.entries[key].size
+		delete(c.entries, key)
+	}
+}
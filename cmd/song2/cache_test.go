@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheIgnoresForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	key := "ab" + strings.Repeat("0", 62)
+	files := map[string]string{
+		"a":                                    "foreign",
+		"notes.txt":                            "foreign",
+		"ab/a":                                 "foreign",
+		"ab/.tmp123":                           "partial",
+		"zz/" + strings.Repeat("f", 64):        "foreign",
+		"cd/" + "ab" + strings.Repeat("1", 62): "wrong directory",
+		"other/deep/" + key:                    "foreign",
+		"ab/" + key:                            "cached",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := openCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 || c.size != int64(len("cached")) {
+		t.Fatalf("indexed %d entries of %d bytes, want only %s", len(c.entries), c.size, key)
+	}
+	if b, ok := c.get(key); !ok || string(b) != "cached" {
+		t.Errorf("get(%s) = %q, %v", key, b, ok)
+	}
+
+	// Filling the cache evicts its own entries but never the foreign files.
+	c.maxSize = 64
+	for i := 0; i < 10; i++ {
+		k := strings.Repeat(string("0123456789"[i]), 64)
+		if err := c.put(k, bytes.Repeat([]byte{'x'}, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.size > c.maxSize {
+		t.Errorf("cache size %d over the limit of %d", c.size, c.maxSize)
+	}
+	for name, content := range files {
+		if content == "cached" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("foreign file %s: %v", name, err)
+		}
+	}
+
+	// The most recently put entry survives, the oldest ones are gone.
+	if _, ok := c.get(strings.Repeat("9", 64)); !ok {
+		t.Error("newest entry was evicted")
+	}
+	if _, ok := c.get(strings.Repeat("0", 64)); ok {
+		t.Error("oldest entry was not evicted")
+	}
+}
+
+func TestCacheTooLargeEntry(t *testing.T) {
+	c, err := openCache(t.TempDir(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.Repeat("a", 64)
+	if err := c.put(key, []byte("too large")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get(key); ok {
+		t.Error("entry larger than the cache was stored")
+	}
+}
+
+func TestCacheCountsEntriesOfOtherProcesses(t *testing.T) {
+	dir := t.TempDir()
+	c, err := openCache(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openCache(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process writes entries after c indexed the directory, and c
+	// reads them.
+	for i := 0; i < 5; i++ {
+		k := strings.Repeat(string("abcde"[i]), 64)
+		if err := other.put(k, bytes.Repeat([]byte{'x'}, 20)); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.get(k); !ok {
+			t.Fatalf("get(%s) missed", k)
+		}
+		if c.size > c.maxSize {
+			t.Fatalf("cache size %d over the limit of %d", c.size, c.maxSize)
+		}
+	}
+
+	var disk int64
+	for k := range c.entries {
+		info, err := os.Stat(c.path(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		disk += info.Size()
+	}
+	if disk != c.size {
+		t.Errorf("cache size %d, but its entries use %d bytes", c.size, disk)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/matsuyoshi30/song2"
+)
+
+// filterOptions holds the flags that select and tune the blur.
+type filterOptions struct {
+	radius     float64
+	mode       string
+	angle      float64
+	length     float64
+	center     string
+	strength   float64
+	samples    int
+	sigmaRange float64
+	block      int
+	smooth     float64
+	rect       string
+	corner     float64
+	tint       string
+	grain      float64
+	variant    string
+	sharpness  float64
+
+	// progress, if not nil, receives the progress of the Gaussian blur.
+	progress func(done, total int)
+	// done, if not nil, cancels the blur once it is closed. The Gaussian
+	// blur stops right away, the others at the end of a phase or frame.
+	done <-chan struct{}
+}
+
+// errCancelled is returned for a blur cancelled through the done option.
+var errCancelled = errors.New("cancelled")
+
+// cancelled returns errCancelled once done is closed.
+func (o *filterOptions) cancelled() error {
+	select {
+	case <-o.done:
+		return errCancelled
+	default:
+		return nil
+	}
+}
+
+// newFilterOptions defines the blur flags on fs and returns the options they
+// set.
+func newFilterOptions(fs *flag.FlagSet) *filterOptions {
+	o := &filterOptions{}
+	fs.Float64Var(&o.radius, "r", 3.0, "Radius")
+	fs.StringVar(&o.mode, "mode", "gaussian", "Blur mode: gaussian, motion, zoom, spin, bilateral, pixelate, frost, kuwahara")
+	fs.Float64Var(&o.angle, "angle", 0.0, "Motion blur angle or spin blur arc in degrees")
+	fs.Float64Var(&o.length, "length", 9.0, "Motion blur length in pixels")
+	fs.StringVar(&o.center, "center", "", "Zoom and spin blur center as x,y")
+	fs.Float64Var(&o.strength, "strength", 0.2, "Zoom blur strength from 0 to 1")
+	fs.IntVar(&o.samples, "samples", 32, "Zoom and spin blur samples per pixel")
+	fs.Float64Var(&o.sigmaRange, "range", 25.0, "Bilateral filter range sigma in 0-255 units")
+	fs.IntVar(&o.block, "block", 16, "Pixelate block size in pixels")
+	fs.Float64Var(&o.smooth, "smooth", 0.0, "Gaussian blur sigma applied after pixelating")
+	fs.StringVar(&o.rect, "rect", "", "Rectangle x,y,w,h to pixelate, or the panel in frost mode")
+	fs.Float64Var(&o.corner, "corner", 16.0, "Frosted glass panel corner radius")
+	fs.StringVar(&o.tint, "tint", "ffffff40", "Frosted glass tint as RRGGBBAA hex")
+	fs.Float64Var(&o.grain, "noise", 0.04, "Frosted glass grain amount from 0 to 1")
+	fs.StringVar(&o.variant, "variant", "classic", "Kuwahara variant: classic, generalized, anisotropic; the last two are much slower at large radii")
+	fs.Float64Var(&o.sharpness, "sharpness", 8.0, "Generalized and anisotropic Kuwahara sharpness")
+	return o
+}
+
+// filter applies the blur selected by the mode option to img.
+func (o *filterOptions) filter(img image.Image) (image.Image, error) {
+	switch o.mode {
+	case "gaussian":
+		return song2.GaussianBlurNRGBAWithOptions(img, o.radius, &song2.BlurOptions{Progress: o.progress, Done: o.done}), nil
+	case "motion":
+		return song2.MotionBlur(img, o.angle, o.length), nil
+	case "zoom":
+		c, err := parseCenter(o.center, img.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		return song2.ZoomBlur(img, c, o.strength, o.samples), nil
+	case "spin":
+		c, err := parseCenter(o.center, img.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		return song2.SpinBlur(img, c, o.angle, o.samples), nil
+	case "bilateral":
+		return song2.FastBilateralFilter(img, o.radius, o.sigmaRange), nil
+	case "pixelate":
+		if o.rect == "" {
+			return song2.Pixelate(img, o.block, o.smooth), nil
+		}
+		r, err := parseRect(o.rect)
+		if err != nil {
+			return nil, err
+		}
+		return song2.PixelateRegion(img, r, o.block, o.smooth), nil
+	case "frost":
+		if o.rect == "" {
+			return nil, fmt.Errorf("frost mode needs the panel rectangle in -rect")
+		}
+		r, err := parseRect(o.rect)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseColor(o.tint)
+		if err != nil {
+			return nil, err
+		}
+		return song2.FrostedGlass(img, r, &song2.FrostedGlassOptions{
+			Radius:       o.radius,
+			CornerRadius: o.corner,
+			Tint:         t,
+			Noise:        o.grain,
+		}), nil
+	case "kuwahara":
+		r := int(math.Round(o.radius))
+		switch o.variant {
+		case "classic":
+			return song2.Kuwahara(img, r), nil
+		case "generalized":
+			return song2.GeneralizedKuwahara(img, r, o.sharpness), nil
+		case "anisotropic":
+			return song2.AnisotropicKuwahara(img, r, o.sharpness, 1), nil
+		default:
+			return nil, fmt.Errorf("unknown kuwahara variant: %s", o.variant)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", o.mode)
+	}
+}
+
+// parseCenter parses a "x,y" point, defaulting to the center of b when s is
+// empty.
+func parseCenter(s string, b image.Rectangle) (image.Point, error) {
+	if s == "" {
+		return image.Pt((b.Min.X+b.Max.X)/2, (b.Min.Y+b.Max.Y)/2), nil
+	}
+
+	xy := strings.Split(s, ",")
+	if len(xy) != 2 {
+		return image.Point{}, fmt.Errorf("invalid center: %s", s)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(xy[0]))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid center: %s", s)
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(xy[1]))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid center: %s", s)
+	}
+
+	return image.Pt(x, y), nil
+}
+
+// parseRect parses a "x,y,w,h" rectangle.
+func parseRect(s string) (image.Rectangle, error) {
+	v := strings.Split(s, ",")
+	if len(v) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle: %s", s)
+	}
+
+	var n [4]int
+	for i := range v {
+		var err error
+		n[i], err = strconv.Atoi(strings.TrimSpace(v[i]))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid rectangle: %s", s)
+		}
+	}
+
+	return image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]), nil
+}
+
+// parseColor parses a RRGGBB or RRGGBBAA hex color, with an optional leading
+// #.
+func parseColor(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 6 {
+		s += "ff"
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// formatExts maps the supported output formats to their file extension.
+var formatExts = map[string]string{
+	"png":  ".png",
+	"jpeg": ".jpg",
+	"gif":  ".gif",
+}
+
+// formatFromPath returns the format matching the extension of path, or "" if
+// it is not a supported one.
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "png"
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".gif":
+		return "gif"
+	}
+	return ""
+}
+
+// outputFormat chooses the output format: an explicit format wins, then the
+// extension of the output path, then the format of the input.
+func outputFormat(explicit, path, input string) (string, error) {
+	f := explicit
+	if f == "" {
+		f = formatFromPath(path)
+	}
+	if f == "" {
+		f = input
+	}
+	if f == "jpg" {
+		f = "jpeg"
+	}
+	if _, ok := formatExts[f]; !ok {
+		return "", fmt.Errorf("unsupported output format: %s", f)
+	}
+	return f, nil
+}
+
+// encode writes img to w in format. JPEG uses quality, and GIF quantizes the
+// colors to a median cut palette with Floyd-Steinberg dithering.
+func encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(w, img, &gif.Options{
+			NumColors: 256,
+			Quantizer: medianCut{},
+			Drawer:    draw.FloydSteinberg,
+		})
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
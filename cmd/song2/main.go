@@ -1,19 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"image"
-	"image/png"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"os"
 	"path/filepath"
-
-	"github.com/matsuyoshi30/song2"
+	"runtime"
 )
 
 var (
-	output = flag.String("o", "blurred.png", "Write output image to specific filepath")
-	radius = flag.Float64("r", 3.0, "Radius")
+	output    = flag.String("o", "", "Write output image to specific filepath")
+	format    = flag.String("format", "", "Output format: png, jpeg, gif")
+	quality   = flag.Int("quality", 90, "JPEG quality from 1 to 100")
+	outDir    = flag.String("outdir", "", "Write the outputs of several inputs to specific directory")
+	nameTmpl  = flag.String("name", "{dir}/{name}{ext}", "Output filename template under -outdir")
+	recursive = flag.Bool("R", false, "Walk directory inputs recursively")
+	jobsFlag  = flag.Int("j", runtime.NumCPU(), "Number of files processed at once")
+	manifest  = flag.String("manifest", "", "Record the status of every file of -outdir to specific filepath and skip completed ones")
+	cacheDir  = flag.String("cache-dir", "", "Cache results in specific directory")
+	cacheSize = flag.Int64("cache-size", 512, "Cache size limit in megabytes")
+	noCache   = flag.Bool("no-cache", false, "Do not read or write the result cache")
+	showBar   = flag.Bool("progress", false, "Show a progress bar on stderr")
+	opts      = newFilterOptions(flag.CommandLine)
+
+	// cache is nil when caching is disabled.
+	cache *resultCache
+	// bar is the progress bar of a single image, nil unless -progress is set.
+	bar *progressBar
 
 	name = "song2"
 )
@@ -24,13 +43,66 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "redact":
+			os.Exit(runRedact(os.Args[2:]))
+		case "transition":
+			os.Exit(runTransition(os.Args[2:]))
+		case "serve":
+			os.Exit(runServe(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage:
-  %s [FLAGS] [FILE]
+  %[1]s [FLAGS] [FILE] [OUTPUT]
+  %[1]s [FLAGS] -outdir DIR FILE|DIR|GLOB...
+  %[1]s redact [FLAGS] [FILE]
+  %[1]s transition [FLAGS] [FILE]
+  %[1]s serve [FLAGS]
 
 FLAGS:
-  -o  Write output image to specifig filepath [default: blurred.png]
+  -o  Write output image to specifig filepath, or - for stdout [default: blurred with the extension of the output format]
+  -format  Output format: png, jpeg, gif [default: from the -o extension, else the input format]
+  -quality  JPEG quality from 1 to 100 [default: 90]
+  -outdir  Write the outputs of several inputs to specific directory
+  -name  Output filename template under -outdir, with {dir}, {name} and {ext} [default: {dir}/{name}{ext}]
+  -R  Walk directory inputs recursively [default: false]
+  -j  Number of files processed at once [default: number of CPUs]
+  -manifest  Record the status of every file of -outdir to specific filepath and skip completed ones
+  -cache-dir  Cache results in specific directory [default: song2 in the user cache directory]
+  -cache-size  Cache size limit in megabytes [default: 512]
+  -no-cache  Do not read or write the result cache [default: false]
+  -progress  Show a progress bar on stderr, or the files done with -outdir [default: false]
   -r  Radius [default: 3.0]
+  -mode  Blur mode: gaussian, motion, zoom, spin, bilateral, pixelate, frost, kuwahara [default: gaussian]
+  -angle  Motion blur angle or spin blur arc in degrees [default: 0.0]
+  -length  Motion blur length in pixels [default: 9.0]
+  -center  Zoom and spin blur center as x,y [default: image center]
+  -strength  Zoom blur strength from 0 to 1 [default: 0.2]
+  -samples  Zoom and spin blur samples per pixel [default: 32]
+  -range  Bilateral filter range sigma in 0-255 units [default: 25.0]
+  -block  Pixelate block size in pixels [default: 16]
+  -smooth  Gaussian blur sigma applied after pixelating [default: 0.0]
+  -rect  Rectangle x,y,w,h to pixelate, or the panel in frost mode [default: whole image]
+  -corner  Frosted glass panel corner radius [default: 16.0]
+  -tint  Frosted glass tint as RRGGBBAA hex [default: ffffff40]
+  -noise  Frosted glass grain amount from 0 to 1 [default: 0.04]
+  -variant  Kuwahara variant: classic, generalized, anisotropic; the last two are much slower at large radii [default: classic]
+  -sharpness  Generalized and anisotropic Kuwahara sharpness [default: 8.0]
+
+FILE is the input image, or - for stdin. OUTPUT, if given, replaces -o but
+must not be an existing image.
+With -outdir, every input is blurred into DIR: directories are walked with -R,
+and quoted glob patterns are expanded. {dir} is the directory of the input
+relative to the walked directory, {name} its name without extension and {ext}
+the extension of the output format.
+
+COMMANDS:
+  redact  Blur or pixelate the regions listed in a file, see %[1]s redact -h
+  transition  Render frames blurring from one radius to another, see %[1]s transition -h
+  serve  Blur images posted over HTTP, see %[1]s serve -h
 
 Author:
   matsuyoshi30 <sfbgwm30@gmail.com>
@@ -38,48 +110,218 @@ Author:
 	}
 	flag.Parse()
 
+	if !*noCache {
+		cache = openResultCache()
+	}
+
 	args := flag.Args()
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "USAGE: %s [FLAGS] [FILE]\n", name)
+	if *outDir != "" {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "USAGE: %s [FLAGS] -outdir DIR FILE|DIR|GLOB...\n", name)
+			return
+		}
+		os.Exit(runBatch(args))
+	}
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "USAGE: %s [FLAGS] [FILE] [OUTPUT]\n", name)
 		return
 	}
+	if len(args) == 2 {
+		// A second input, as from song2 *.png, must not be overwritten.
+		if args[1] != "-" && isImage(args[1]) {
+			fmt.Fprintf(os.Stderr, "%s is an existing image: pass it with -o to overwrite it, or use -outdir DIR to blur several inputs\n", args[1])
+			os.Exit(exitCodeErr)
+		}
+		*output = args[1]
+	}
 
 	os.Exit(run(args[0]))
 }
 
 func run(src string) int {
-	pwd, err := os.Getwd()
+	if *showBar {
+		bar = &progressBar{}
+		opts.progress = bar.count
+	}
+
+	bar.setPhase("read", 0, 0.05)
+	data, err := readInput(src)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return exitCodeErr
 	}
 
-	file, err := os.Open(filepath.Join(pwd, src))
+	err = convert(data, func(inputFormat string) (string, string, error) {
+		f, err := outputFormat(*format, *output, inputFormat)
+		if err != nil {
+			return "", "", err
+		}
+		dst := *output
+		if dst == "" {
+			dst = "blurred" + formatExts[f]
+		}
+		return dst, f, nil
+	})
 	if err != nil {
+		if bar != nil {
+			fmt.Fprintln(os.Stderr)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		return exitCodeErr
 	}
-	defer file.Close()
+	bar.finish()
+
+	return exitCodeOK
+}
+
+// convert filters data and encodes it. target chooses the output path and
+// format from the format of the input. A result found in the cache is
+// written without decoding data.
+func convert(data []byte, target func(inputFormat string) (string, string, error)) error {
+	_, inputFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
 
-	img, _, err := image.Decode(file)
+	dst, outFormat, err := target(inputFormat)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return exitCodeErr
+		return err
 	}
 
-	blurred := song2.GaussianBlur(img, *radius)
+	var b []byte
+	var key string
+	if cache != nil {
+		key = cacheKey(data, outFormat)
+		b, _ = cache.get(key)
+	}
+	if b == nil {
+		bar.setPhase("decode", 0.05, 0.2)
+		var r io.Reader = bytes.NewReader(data)
+		if bar != nil {
+			r = &progressReader{r: r, total: len(data), bar: bar}
+		}
+		img, _, err := image.Decode(r)
+		if err != nil {
+			return err
+		}
+		if b, err = render(data, img, inputFormat, outFormat, *quality, opts); err != nil {
+			return err
+		}
+		if cache != nil {
+			if err := cache.put(key, b); err != nil {
+				fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+			}
+		}
+	}
 
-	out, err := os.Create(filepath.Join(pwd, *output))
+	out, err := createOutput(dst)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return exitCodeErr
+		return err
+	}
+	if _, err := out.Write(b); err != nil {
+		out.Close()
+		return err
 	}
-	defer out.Close()
+	return out.Close()
+}
 
-	if err := png.Encode(out, blurred); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return exitCodeErr
+// openResultCache opens the cache of the -cache-dir and -cache-size flags.
+// The CLI still works without a cache, so errors only print a warning.
+func openResultCache() *resultCache {
+	dir := *cacheDir
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(d, name)
 	}
 
-	return exitCodeOK
+	c, err := openCache(dir, *cacheSize<<20)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache disabled: %v\n", err)
+		return nil
+	}
+	return c
+}
+
+// render filters img, decoded from data, with o and returns it encoded in
+// outFormat. It returns errCancelled as soon as o is cancelled.
+func render(data []byte, img image.Image, inputFormat, outFormat string, quality int, o *filterOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := o.cancelled(); err != nil {
+		return nil, err
+	}
+
+	// image.Decode only reads the first frame, so animated GIFs written as
+	// GIF are decoded again with all their frames.
+	if inputFormat == "gif" && outFormat == "gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if len(g.Image) > 1 {
+			bar.setPhase("blur", 0.2, 0.9)
+			anim, err := blurGIF(g, o)
+			if err != nil {
+				return nil, err
+			}
+			if err := o.cancelled(); err != nil {
+				return nil, err
+			}
+			bar.setPhase("encode", 0.9, 1)
+			if err := gif.EncodeAll(&buf, anim); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+	}
+
+	bar.setPhase("blur", 0.2, 0.9)
+	blurred, err := o.filter(img)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.cancelled(); err != nil {
+		return nil, err
+	}
+
+	bar.setPhase("encode", 0.9, 1)
+	if err := encode(&buf, blurred, outFormat, quality); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
+
+// isImage reports whether path is a file in a format that can be decoded.
+func isImage(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, _, err = image.DecodeConfig(f)
+	return err == nil
+}
+
+// readInput reads the file at path, or stdin if path is "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// createOutput creates path for writing, or returns stdout if path is "-".
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"sync"
+	"time"
+)
+
+// manifestEntry is the status of one input of a batch, written as one JSON
+// line to the manifest.
+type manifestEntry struct {
+	Time        time.Time         `json:"time"`
+	Input       string            `json:"input"`
+	InputSHA256 string            `json:"input_sha256"`
+	Params      map[string]string `json:"params"`
+	Output      string            `json:"output,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// batchManifest appends entries to a manifest file and remembers the entries
+// read from it when the batch started.
+type batchManifest struct {
+	mu   sync.Mutex
+	f    *os.File
+	prev map[string]manifestEntry
+}
+
+// openManifest reads the manifest at path, if any, and opens it for
+// appending. A line cut short by a crash is ignored, and the last entry of an
+// input wins.
+func openManifest(path string) (*batchManifest, error) {
+	m := &batchManifest{prev: map[string]manifestEntry{}}
+
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var e manifestEntry
+			if json.Unmarshal(sc.Bytes(), &e) == nil {
+				m.prev[e.Input] = e
+			}
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// completed returns the previous entry of input if it succeeded with the same
+// input content and parameters and its output still exists.
+func (m *batchManifest) completed(input, sum string, params map[string]string) (manifestEntry, bool) {
+	e, ok := m.prev[input]
+	if !ok || e.Error != "" || e.InputSHA256 != sum || !sameParams(e.Params, params) {
+		return manifestEntry{}, false
+	}
+	if _, err := os.Stat(e.Output); err != nil {
+		return manifestEntry{}, false
+	}
+	return e, true
+}
+
+// record appends e to the manifest. Each entry is written with a single
+// write so that entries of concurrent files do not interleave.
+func (m *batchManifest) record(e manifestEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.f.Write(append(b, '\n'))
+	return err
+}
+
+func (m *batchManifest) Close() error {
+	return m.f.Close()
+}
+
+// batchParams returns the value of every flag that changes the output
+// images or their paths, -outdir included, so that a manifest entry is only
+// reused with the same parameters.
+func batchParams() map[string]string {
+	return flagValues("o", "R", "j", "manifest", "cache-dir", "cache-size", "no-cache", "progress")
+}
+
+// flagValues returns the value of every command line flag but skip.
+func flagValues(skip ...string) map[string]string {
+	skipped := map[string]bool{}
+	for _, name := range skip {
+		skipped[name] = true
+	}
+
+	params := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !skipped[f.Name] {
+			params[f.Name] = f.Value.String()
+		}
+	})
+	return params
+}
+
+func sameParams(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
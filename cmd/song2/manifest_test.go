@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setFlag sets a command line flag for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+func writeTestPNG(t *testing.T, path string) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 13)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readManifest(t *testing.T, path string) []manifestEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []manifestEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e manifestEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestManifestResume(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.png")
+	writeTestPNG(t, src)
+	manifestPath := filepath.Join(dir, "manifest.jsonl")
+	setFlag(t, "manifest", manifestPath)
+	setFlag(t, "r", "1")
+
+	tests := []struct {
+		name        string
+		outdir      string
+		radius      string
+		wantEntries int
+	}{
+		{"first run", "out1", "1", 1},
+		{"same outdir is skipped", "out1", "1", 1},
+		{"new outdir is converted", "out2", "1", 2},
+		{"new outdir again is skipped", "out2", "1", 2},
+		{"new radius is converted", "out2", "2", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outdir := filepath.Join(dir, tt.outdir)
+			setFlag(t, "outdir", outdir)
+			setFlag(t, "r", tt.radius)
+
+			if code := runJobs([]job{{src: src}}); code != exitCodeOK {
+				t.Fatalf("runJobs() = %d", code)
+			}
+
+			entries := readManifest(t, manifestPath)
+			if len(entries) != tt.wantEntries {
+				t.Fatalf("manifest has %d entries, want %d", len(entries), tt.wantEntries)
+			}
+			last := entries[len(entries)-1]
+			want := filepath.Join(outdir, "in.png")
+			if last.Output != want || last.Error != "" {
+				t.Errorf("last entry output %q error %q, want output %q", last.Output, last.Error, want)
+			}
+			if _, err := os.Stat(want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestManifestRecordsReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "manifest.jsonl")
+	setFlag(t, "manifest", manifestPath)
+	setFlag(t, "outdir", filepath.Join(dir, "out"))
+
+	src := filepath.Join(dir, "missing.png")
+	if code := runJobs([]job{{src: src}}); code != exitCodeErr {
+		t.Fatalf("runJobs() = %d, want %d", code, exitCodeErr)
+	}
+
+	entries := readManifest(t, manifestPath)
+	if len(entries) != 1 || entries[0].Input != src || entries[0].Error == "" {
+		t.Fatalf("manifest = %+v, want one error entry for %s", entries, src)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// progressWidth is the number of cells of the progress bar.
+const progressWidth = 30
+
+// progressBar draws the progress of the phases of a run on stderr. Each
+// phase covers a share of the bar, and the bar is only redrawn when the
+// percentage changes. A nil *progressBar draws nothing.
+type progressBar struct {
+	mu         sync.Mutex
+	phase      string
+	start, end float64
+	percent    int
+}
+
+// setPhase starts the phase called name, which fills the bar from start to
+// end, both from 0 to 1.
+func (b *progressBar) setPhase(name string, start, end float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.phase, b.start, b.end = name, start, end
+	b.draw(start)
+}
+
+// update sets how much of the current phase is done, from 0 to 1.
+func (b *progressBar) update(done float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw(b.start + (b.end-b.start)*done)
+}
+
+// count is a song2.BlurOptions Progress callback updating the current phase.
+func (b *progressBar) count(done, total int) {
+	if total > 0 {
+		b.update(float64(done) / float64(total))
+	}
+}
+
+// finish fills the bar and ends its line.
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.phase = "done"
+	b.percent = -1
+	b.draw(1)
+	fmt.Fprintln(os.Stderr)
+}
+
+// draw redraws the bar at fraction f. b.mu must be held.
+func (b *progressBar) draw(f float64) {
+	p := int(f * 100)
+	if p == b.percent {
+		return
+	}
+	b.percent = p
+
+	n := p * progressWidth / 100
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3d%% %-8s", strings.Repeat("#", n), strings.Repeat(".", progressWidth-n), p, b.phase)
+}
+
+// progressReader reports the share of its total size read so far to bar.
+type progressReader struct {
+	r     io.Reader
+	read  int
+	total int
+	bar   *progressBar
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += n
+	if r.total > 0 {
+		r.bar.update(float64(r.read) / float64(r.total))
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// maxQuantizeSamples bounds the number of pixels medianCut looks at.
+const maxQuantizeSamples = 1 << 18
+
+// medianCut is a draw.Quantizer that builds a palette by repeatedly splitting
+// the box of colors with the widest channel range at its median. If the image
+// has transparent pixels, one entry is reserved for transparency.
+type medianCut struct{}
+
+func (medianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	b := m.Bounds()
+	step := 1
+	for b.Dx()*b.Dy()/(step*step) > maxQuantizeSamples {
+		step++
+	}
+
+	var colors [][3]uint8
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				transparent = true
+				continue
+			}
+			colors = append(colors, [3]uint8{c.R, c.G, c.B})
+		}
+	}
+
+	n := cap(p) - len(p)
+	if transparent {
+		p = append(p, color.RGBA{})
+		n--
+	}
+	if n <= 0 || len(colors) == 0 {
+		return p
+	}
+
+	boxes := [][][3]uint8{colors}
+	for len(boxes) < n {
+		i, ch, width := -1, 0, 0
+		for j, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			c, w := widestChannel(box)
+			if w > width {
+				i, ch, width = j, c, w
+			}
+		}
+		if i < 0 {
+			break
+		}
+
+		box := boxes[i]
+		sort.Slice(box, func(a, b int) bool { return box[a][ch] < box[b][ch] })
+		mid := len(box) / 2
+		boxes[i] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	for _, box := range boxes {
+		var sum [3]int
+		for _, c := range box {
+			for k := range sum {
+				sum[k] += int(c[k])
+			}
+		}
+		l := len(box)
+		p = append(p, color.RGBA{uint8(sum[0] / l), uint8(sum[1] / l), uint8(sum[2] / l), 0xff})
+	}
+
+	return p
+}
+
+// widestChannel returns the channel with the largest range in box, and the
+// range.
+func widestChannel(box [][3]uint8) (int, int) {
+	lo := [3]uint8{255, 255, 255}
+	var hi [3]uint8
+	for _, c := range box {
+		for k := range c {
+			if c[k] < lo[k] {
+				lo[k] = c[k]
+			}
+			if c[k] > hi[k] {
+				hi[k] = c[k]
+			}
+		}
+	}
+
+	ch, width := 0, -1
+	for k := range lo {
+		if w := int(hi[k]) - int(lo[k]); w > width {
+			ch, width = k, w
+		}
+	}
+	return ch, width
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matsuyoshi30/song2"
+)
+
+// region is one area to redact, as read from a regions file.
+type region struct {
+	Shape    string       `json:"shape"`
+	Method   string       `json:"method"`
+	Strength float64      `json:"strength,omitempty"`
+	X        int          `json:"x,omitempty"`
+	Y        int          `json:"y,omitempty"`
+	Width    int          `json:"width,omitempty"`
+	Height   int          `json:"height,omitempty"`
+	Points   [][2]float64 `json:"points,omitempty"`
+}
+
+// auditRecord describes a redaction so that it can be verified later.
+type auditRecord struct {
+	Time         time.Time     `json:"time"`
+	Input        string        `json:"input"`
+	InputSHA256  string        `json:"input_sha256"`
+	Output       string        `json:"output"`
+	OutputSHA256 string        `json:"output_sha256"`
+	Width        int           `json:"width"`
+	Height       int           `json:"height"`
+	Regions      []auditRegion `json:"regions"`
+}
+
+type auditRegion struct {
+	Index    int       `json:"index"`
+	Shape    string    `json:"shape"`
+	Method   string    `json:"method"`
+	Strength float64   `json:"strength"`
+	Bounds   auditRect `json:"bounds"`
+	Pixels   int       `json:"pixels"`
+}
+
+type auditRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func runRedact(args []string) int {
+	fs := flag.NewFlagSet(name+" redact", flag.ExitOnError)
+	regionsFile := fs.String("regions", "", "JSON or CSV file of regions to redact")
+	output := fs.String("o", "redacted.png", "Write output image to specific filepath")
+	format := fs.String("format", "", "Output format: png, jpeg, gif")
+	quality := fs.Int("quality", 90, "JPEG quality from 1 to 100")
+	audit := fs.String("audit", "", "Write the audit record to specific filepath")
+	radius := fs.Float64("r", 10.0, "Default blur radius")
+	block := fs.Int("block", 16, "Default pixelate block size in pixels")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage:
+  %s redact [FLAGS] [FILE]
+
+FLAGS:
+  -regions  JSON or CSV file of regions to redact
+  -o  Write output image to specific filepath [default: redacted.png]
+  -format  Output format: png, jpeg, gif [default: from the -o extension, else the input format]
+  -quality  JPEG quality from 1 to 100 [default: 90]
+  -audit  Write the audit record to specific filepath [default: output path + .audit.json]
+  -r  Default blur radius, at least 3 [default: 10.0]
+  -block  Default pixelate block size in pixels, at least 4 [default: 16]
+`, name)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *regionsFile == "" {
+		fs.Usage()
+		return exitCodeErr
+	}
+	if *audit == "" {
+		*audit = *output + ".audit.json"
+	}
+
+	regions, err := readRegions(*regionsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	src := fs.Arg(0)
+	data, err := os.ReadFile(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	img, inputFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	outFormat, err := outputFormat(*format, *output, inputFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	dst := song2.CloneToRGBA(img)
+	record := auditRecord{
+		Time:        time.Now().UTC(),
+		Input:       src,
+		InputSHA256: sha256Hex(data),
+		Output:      *output,
+		Width:       dst.Bounds().Dx(),
+		Height:      dst.Bounds().Dy(),
+	}
+
+	for i, r := range regions {
+		if r.Strength == 0 {
+			switch r.Method {
+			case "blur":
+				r.Strength = *radius
+			case "pixelate":
+				r.Strength = float64(*block)
+			}
+		}
+
+		ar, err := redact(dst, r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "region %d: %v\n", i, err)
+			return exitCodeErr
+		}
+		ar.Index = i
+		record.Regions = append(record.Regions, ar)
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, dst, outFormat, *quality); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	record.OutputSHA256 = sha256Hex(buf.Bytes())
+
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	b, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	if err := os.WriteFile(*audit, append(b, '\n'), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	return exitCodeOK
+}
+
+// Strengths below these do not hide the content of a region, so they are
+// rejected rather than recorded as redacted.
+const (
+	minRedactBlur  = 3.0
+	minRedactBlock = 4
+)
+
+// checkStrength returns an error if r has an unknown method or a strength
+// too weak to obscure it.
+func checkStrength(r region) error {
+	switch r.Method {
+	case "blur":
+		if r.Strength < minRedactBlur {
+			return fmt.Errorf("blur strength %g is below the minimum of %g", r.Strength, minRedactBlur)
+		}
+	case "pixelate":
+		if int(r.Strength) < minRedactBlock {
+			return fmt.Errorf("pixelate strength %g is below the minimum of %d", r.Strength, minRedactBlock)
+		}
+	default:
+		return fmt.Errorf("unknown method: %s", r.Method)
+	}
+	return nil
+}
+
+// redact blurs or pixelates the pixels of dst inside r in place. Only pixels
+// inside the bounding box of r are read, so nothing outside it leaks in.
+func redact(dst *image.RGBA, r region) (auditRegion, error) {
+	if err := checkStrength(r); err != nil {
+		return auditRegion{}, err
+	}
+
+	mask, err := regionMask(r, dst.Bounds())
+	if err != nil {
+		return auditRegion{}, err
+	}
+
+	b := mask.Bounds()
+	ar := auditRegion{
+		Shape:    r.Shape,
+		Method:   r.Method,
+		Strength: r.Strength,
+		Bounds:   auditRect{X: b.Min.X, Y: b.Min.Y, Width: b.Dx(), Height: b.Dy()},
+	}
+	for _, a := range mask.Pix {
+		if a != 0 {
+			ar.Pixels++
+		}
+	}
+	if ar.Pixels == 0 {
+		return ar, nil
+	}
+
+	sub := dst.SubImage(b)
+	var redacted *image.RGBA
+	switch r.Method {
+	case "blur":
+		redacted = song2.GaussianBlur(sub, r.Strength)
+	case "pixelate":
+		redacted = song2.Pixelate(sub, int(r.Strength), 0)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if mask.Pix[mask.PixOffset(x, y)] != 0 {
+				dst.SetRGBA(x, y, redacted.RGBAAt(x, y))
+			}
+		}
+	}
+
+	return ar, nil
+}
+
+// regionMask returns an opaque mask of the pixels whose centers lie inside r,
+// bounded by the bounding box of r clipped to b.
+func regionMask(r region, b image.Rectangle) (*image.Alpha, error) {
+	var inside func(x, y float64) bool
+	var bounds image.Rectangle
+
+	switch r.Shape {
+	case "rect":
+		bounds = image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)
+		inside = func(x, y float64) bool { return true }
+	case "ellipse":
+		bounds = image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)
+		cx, cy := float64(r.X)+float64(r.Width)/2, float64(r.Y)+float64(r.Height)/2
+		rx, ry := float64(r.Width)/2, float64(r.Height)/2
+		inside = func(x, y float64) bool {
+			dx, dy := (x-cx)/rx, (y-cy)/ry
+			return dx*dx+dy*dy <= 1
+		}
+	case "polygon":
+		if len(r.Points) < 3 {
+			return nil, fmt.Errorf("polygon needs at least 3 points")
+		}
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range r.Points {
+			minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+			minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+		}
+		bounds = image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+		inside = func(x, y float64) bool { return insidePolygon(r.Points, x, y) }
+	default:
+		return nil, fmt.Errorf("unknown shape: %s", r.Shape)
+	}
+
+	bounds = bounds.Intersect(b)
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if inside(float64(x)+0.5, float64(y)+0.5) {
+				mask.Pix[mask.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// insidePolygon reports whether (x, y) is inside the polygon using the even
+// odd rule.
+func insidePolygon(points [][2]float64, x, y float64) bool {
+	in := false
+	j := len(points) - 1
+	for i := range points {
+		xi, yi := points[i][0], points[i][1]
+		xj, yj := points[j][0], points[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+		j = i
+	}
+	return in
+}
+
+// readRegions reads regions from a JSON file holding an array of regions, or
+// from a CSV file whose rows are
+//
+//	shape,method,strength,x,y,width,height
+//
+// for rect and ellipse, and shape,method,strength,x1,y1,x2,y2,... for
+// polygon. An empty strength uses the default of the method.
+func readRegions(path string) ([]region, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		var regions []region
+		if err := json.NewDecoder(f).Decode(&regions); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return regions, nil
+	}
+
+	return readRegionsCSV(f)
+}
+
+func readRegionsCSV(r io.Reader) ([]region, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	var regions []region
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && rec[0] == "shape" {
+			continue
+		}
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("line %d: too few fields", line)
+		}
+
+		reg := region{Shape: rec[0], Method: rec[1]}
+		if rec[2] != "" {
+			if reg.Strength, err = strconv.ParseFloat(rec[2], 64); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+
+		coords := make([]float64, len(rec)-3)
+		for i, s := range rec[3:] {
+			if coords[i], err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+
+		switch reg.Shape {
+		case "polygon":
+			if len(coords)%2 != 0 {
+				return nil, fmt.Errorf("line %d: odd number of polygon coordinates", line)
+			}
+			for i := 0; i < len(coords); i += 2 {
+				reg.Points = append(reg.Points, [2]float64{coords[i], coords[i+1]})
+			}
+		default:
+			if len(coords) != 4 {
+				return nil, fmt.Errorf("line %d: want x,y,width,height", line)
+			}
+			reg.X, reg.Y = int(coords[0]), int(coords[1])
+			reg.Width, reg.Height = int(coords[2]), int(coords[3])
+		}
+
+		regions = append(regions, reg)
+	}
+
+	return regions, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
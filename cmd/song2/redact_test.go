@@ -0,0 +1,122 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []region
+		wantErr bool
+	}{
+		{
+			name:    "csv rect with header",
+			file:    "regions.csv",
+			content: "shape,method,strength,x,y,width,height\nrect,blur,12,1,2,30,40\n",
+			want:    []region{{Shape: "rect", Method: "blur", Strength: 12, X: 1, Y: 2, Width: 30, Height: 40}},
+		},
+		{
+			name:    "csv default strength and comment",
+			file:    "regions.csv",
+			content: "# faces\nellipse,pixelate,,5,6,7,8\n",
+			want:    []region{{Shape: "ellipse", Method: "pixelate", X: 5, Y: 6, Width: 7, Height: 8}},
+		},
+		{
+			name:    "csv polygon",
+			file:    "regions.csv",
+			content: "polygon,blur,5,0,0,10,0,10,10\n",
+			want:    []region{{Shape: "polygon", Method: "blur", Strength: 5, Points: [][2]float64{{0, 0}, {10, 0}, {10, 10}}}},
+		},
+		{
+			name:    "csv odd polygon coordinates",
+			file:    "regions.csv",
+			content: "polygon,blur,5,0,0,10\n",
+			wantErr: true,
+		},
+		{
+			name:    "csv rect without size",
+			file:    "regions.csv",
+			content: "rect,blur,5,0,0\n",
+			wantErr: true,
+		},
+		{
+			name:    "json",
+			file:    "regions.json",
+			content: `[{"shape": "rect", "method": "pixelate", "strength": 8, "x": 1, "y": 2, "width": 3, "height": 4}]`,
+			want:    []region{{Shape: "rect", Method: "pixelate", Strength: 8, X: 1, Y: 2, Width: 3, Height: 4}},
+		},
+		{
+			name:    "invalid json",
+			file:    "regions.json",
+			content: `[{"shape": }]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readRegions(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRegions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readRegions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactStrength(t *testing.T) {
+	tests := []struct {
+		name    string
+		region  region
+		wantErr string
+	}{
+		{"pixelate of 1", region{Shape: "rect", Method: "pixelate", Strength: 1, Width: 20, Height: 20}, "below the minimum"},
+		{"pixelate below minimum", region{Shape: "rect", Method: "pixelate", Strength: 3.9, Width: 20, Height: 20}, "below the minimum"},
+		{"weak blur", region{Shape: "rect", Method: "blur", Strength: 0.1, Width: 20, Height: 20}, "below the minimum"},
+		{"unknown method outside the image", region{Shape: "rect", Method: "nope", Strength: 5, X: 100, Y: 100, Width: 5, Height: 5}, "unknown method"},
+		{"pixelate", region{Shape: "rect", Method: "pixelate", Strength: 4, Width: 20, Height: 20}, ""},
+		{"blur", region{Shape: "ellipse", Method: "blur", Strength: 3, Width: 20, Height: 20}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+			for i := range img.Pix {
+				img.Pix[i] = uint8(i * 37)
+			}
+			before := append([]uint8(nil), img.Pix...)
+
+			ar, err := redact(img, tt.region)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("redact() error = %v, want %q", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(img.Pix, before) {
+					t.Error("redact() changed the image of a rejected region")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("redact() error = %v", err)
+			}
+			if ar.Pixels == 0 || reflect.DeepEqual(img.Pix, before) {
+				t.Errorf("redact() redacted nothing, audit pixels %d", ar.Pixels)
+			}
+		})
+	}
+}
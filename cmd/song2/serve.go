@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// errTooLarge is returned by readLimited for bodies over the size limit.
+var errTooLarge = errors.New("image too large")
+
+// errInternal is returned for a blur that panicked.
+var errInternal = errors.New("internal error")
+
+// servedModes are the blur modes that stop when their request times out, and
+// so the only ones the server runs.
+var servedModes = map[string]bool{"gaussian": true}
+
+// server blurs the images posted to it, with the options given as query
+// parameters under the same names as the CLI flags.
+type server struct {
+	maxBytes  int64
+	maxPixels int
+	timeout   time.Duration
+	slots     chan struct{}
+}
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet(name+" serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	maxBytes := fs.Int64("max-bytes", 32<<20, "Largest accepted upload in bytes")
+	maxPixels := fs.Int("max-pixels", 50_000_000, "Largest accepted image in pixels, all frames of a GIF together")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time limit of a request")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "Number of images blurred at once")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage:
+  %[1]s serve [FLAGS]
+
+FLAGS:
+  -addr  Address to listen on [default: :8080]
+  -max-bytes  Largest accepted upload in bytes [default: 33554432]
+  -max-pixels  Largest accepted image in pixels, all frames of a GIF together [default: 50000000]
+  -timeout  Time limit of a request [default: 30s]
+  -concurrency  Number of images blurred at once [default: number of CPUs]
+
+POST an image to /blur, as the raw body or as the "image" field of a
+multipart form. The query parameters are the blur flags of %[1]s, such as
+r, and format and quality for the output. Only the gaussian mode is served,
+as the other modes cannot be stopped when a request times out. For example:
+
+  curl --data-binary @input.png 'localhost:8080/blur?r=5&format=jpeg' > out.jpg
+`, name)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *concurrency < 1 {
+		fs.Usage()
+		return exitCodeErr
+	}
+
+	s := &server{
+		maxBytes:  *maxBytes,
+		maxPixels: *maxPixels,
+		timeout:   *timeout,
+		slots:     make(chan struct{}, *concurrency),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/blur", s)
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *timeout,
+		IdleTimeout:       time.Minute,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	return exitCodeOK
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	// Every request parses its query into fresh flags, so that concurrent
+	// requests do not share options.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o := newFilterOptions(fs)
+	format := fs.String("format", "", "")
+	quality := fs.Int("quality", 90, "")
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			if err := fs.Set(k, v); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", k, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	data, err := s.readImage(r)
+	if err != nil {
+		if err == errTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	// Check the size from the header before decoding the whole image. The
+	// frames of an animated GIF are counted once it holds a slot.
+	cfg, inputFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.Width*cfg.Height > s.maxPixels {
+		http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	outFormat, err := outputFormat(*format, "", inputFormat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkOptions(o, cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		http.Error(w, "server busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	// A request that times out cancels its blur, which gives up its slot at
+	// the next check instead of running to the end.
+	o.done = ctx.Done()
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// net/http cannot recover a panic in this goroutine, and it would
+		// take the whole server down.
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("blur: %v\n%s", p, debug.Stack())
+				done <- result{err: errInternal}
+			}
+			<-s.slots
+		}()
+		b, err := s.blur(data, inputFormat, outFormat, *quality, o)
+		done <- result{b, err}
+	}()
+
+	select {
+	case res := <-done:
+		switch {
+		case res.err == errTooLarge:
+			http.Error(w, res.err.Error(), http.StatusRequestEntityTooLarge)
+		case res.err == errCancelled:
+			http.Error(w, "timed out", http.StatusServiceUnavailable)
+		case res.err == errInternal:
+			http.Error(w, res.err.Error(), http.StatusInternalServerError)
+		case res.err != nil:
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		default:
+			w.Header().Set("Content-Type", "image/"+outFormat)
+			w.Header().Set("Content-Length", strconv.Itoa(len(res.b)))
+			w.Write(res.b)
+		}
+	case <-ctx.Done():
+		http.Error(w, "timed out", http.StatusServiceUnavailable)
+	}
+}
+
+// checkOptions rejects the options that the server does not run on an image
+// of cfg: modes that a timeout cannot stop, and radii that are not finite or
+// go beyond the image.
+func checkOptions(o *filterOptions, cfg image.Config) error {
+	if !servedModes[o.mode] {
+		return fmt.Errorf("mode %s is not served, only gaussian stops on timeout", o.mode)
+	}
+	if max := float64(cfg.Width + cfg.Height); !(o.radius >= 0 && o.radius <= max) {
+		return fmt.Errorf("r must be between 0 and %v for this image", max)
+	}
+	return nil
+}
+
+// blur decodes data and renders it with o. An animated GIF blurs every frame,
+// so its frames all count towards the pixel limit.
+func (s *server) blur(data []byte, inputFormat, outFormat string, quality int, o *filterOptions) ([]byte, error) {
+	if err := o.cancelled(); err != nil {
+		return nil, err
+	}
+	if inputFormat == "gif" && outFormat == "gif" {
+		g, err := gif.DecodeAll(&cancelReader{r: bytes.NewReader(data), o: o})
+		if err != nil {
+			return nil, err
+		}
+		if len(g.Image)*g.Config.Width*g.Config.Height > s.maxPixels {
+			return nil, errTooLarge
+		}
+	}
+
+	img, _, err := image.Decode(&cancelReader{r: bytes.NewReader(data), o: o})
+	if err != nil {
+		return nil, err
+	}
+	return render(data, img, inputFormat, outFormat, quality, o)
+}
+
+// cancelReader fails with errCancelled once o is cancelled, so that a
+// timed out request also stops decoding.
+type cancelReader struct {
+	r io.Reader
+	o *filterOptions
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	if err := r.o.cancelled(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// readImage returns the uploaded image: the "image" field of a multipart
+// form, or else the raw body.
+func (s *server) readImage(r *http.Request) ([]byte, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "multipart/form-data" {
+		return readLimited(r.Body, s.maxBytes)
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no image field in form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "image" {
+			return readLimited(part, s.maxBytes)
+		}
+	}
+}
+
+// readLimited reads r to the end, or returns errTooLarge if it holds more
+// than n bytes.
+func readLimited(r io.Reader, n int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, n+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > n {
+		return nil, errTooLarge
+	}
+	return b, nil
+}
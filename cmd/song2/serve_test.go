@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestCheckOptions(t *testing.T) {
+	cfg := image.Config{Width: 40, Height: 30}
+	tests := []struct {
+		query   map[string]string
+		wantErr bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"r": "70"}, false},
+		{map[string]string{"r": "0"}, false},
+		{map[string]string{"r": "70.5"}, true},
+		{map[string]string{"r": "-1"}, true},
+		{map[string]string{"r": "NaN"}, true},
+		{map[string]string{"r": "Inf"}, true},
+		{map[string]string{"mode": "motion", "length": "1e9"}, true},
+		{map[string]string{"mode": "kuwahara", "variant": "generalized"}, true},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		o := newFilterOptions(fs)
+		for k, v := range tt.query {
+			if err := fs.Set(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := checkOptions(o, cfg); (err != nil) != tt.wantErr {
+			t.Errorf("checkOptions(%v) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/matsuyoshi30/song2"
+)
+
+func runTransition(args []string) int {
+	fs := flag.NewFlagSet(name+" transition", flag.ExitOnError)
+	output := fs.String("o", "transition.gif", "Write an animated GIF, or numbered PNGs for a .png path, or - for a GIF on stdout")
+	from := fs.Float64("from", 0.0, "Blur radius of the first frame")
+	to := fs.Float64("to", 10.0, "Blur radius of the last frame")
+	frames := fs.Int("frames", 30, "Number of frames")
+	delay := fs.Int("delay", 40, "Delay between GIF frames in milliseconds")
+	loop := fs.Int("loop", 0, "GIF loop count, 0 loops forever and -1 plays once")
+	easing := fs.String("ease", "inout", "Easing: linear, in, out, inout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage:
+  %s transition [FLAGS] [FILE]
+
+FLAGS:
+  -o  Write an animated GIF, or numbered PNGs for a .png path, or - for a GIF on stdout [default: transition.gif]
+  -from  Blur radius of the first frame [default: 0.0]
+  -to  Blur radius of the last frame [default: 10.0]
+  -frames  Number of frames [default: 30]
+  -delay  Delay between GIF frames in milliseconds [default: 40]
+  -loop  GIF loop count, 0 loops forever and -1 plays once [default: 0]
+  -ease  Easing: linear, in, out, inout [default: inout]
+
+A .png output path may hold a printf verb for the frame number, such as
+frames/intro_%%03d.png; otherwise _%%03d is added before the extension.
+`, name)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *frames < 1 {
+		fs.Usage()
+		return exitCodeErr
+	}
+
+	ease, ok := easings[*easing]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown easing: %s\n", *easing)
+		return exitCodeErr
+	}
+
+	var sequence bool
+	switch {
+	case *output == "-", formatFromPath(*output) == "gif":
+	case formatFromPath(*output) == "png":
+		sequence = true
+	default:
+		fmt.Fprintf(os.Stderr, "output must be a .gif or .png path, or - for a GIF on stdout: %s\n", *output)
+		return exitCodeErr
+	}
+
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	pattern := *output
+	if sequence && !strings.Contains(pattern, "%") {
+		ext := filepath.Ext(pattern)
+		pattern = strings.TrimSuffix(pattern, ext) + "_%03d" + ext
+	}
+
+	// Frames are blurred one after another, since each blur already uses
+	// every CPU, and only the single threaded quantization of GIF frames runs
+	// concurrently, for at most NumCPU frames at a time.
+	anim := &gif.GIF{
+		Image:     make([]*image.Paletted, *frames),
+		Delay:     make([]int, *frames),
+		Disposal:  make([]byte, *frames),
+		LoopCount: *loop,
+	}
+	errs := make([]error, *frames)
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i := 0; i < *frames; i++ {
+		t := 0.0
+		if *frames > 1 {
+			t = float64(i) / float64(*frames-1)
+		}
+		frame := song2.GaussianBlurNRGBA(img, *from+(*to-*from)*ease(t))
+
+		if sequence {
+			if errs[i] = writePNG(fmt.Sprintf(pattern, i), frame); errs[i] != nil {
+				break
+			}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			anim.Image[i], anim.Disposal[i] = palettedFrame(frame)
+			anim.Delay[i] = (*delay + 5) / 10
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeErr
+		}
+	}
+	if sequence {
+		return exitCodeOK
+	}
+
+	out, err := createOutput(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	if err := gif.EncodeAll(out, anim); err != nil {
+		out.Close()
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeErr
+	}
+
+	return exitCodeOK
+}
+
+// easings map t from 0 to 1 onto the progress of the transition.
+var easings = map[string]func(t float64) float64{
+	"linear": func(t float64) float64 { return t },
+	"in":     func(t float64) float64 { return t * t * t },
+	"out":    func(t float64) float64 { return 1 - math.Pow(1-t, 3) },
+	"inout": func(t float64) float64 {
+		if t < 0.5 {
+			return 4 * t * t * t
+		}
+		return 1 - math.Pow(-2*t+2, 3)/2
+	},
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,188 @@
+package song2
+
+import (
+	"image"
+	"math"
+)
+
+// ConvolveSeparable convolves src with kernelX along each row and then with
+// kernelY along each column. Both kernels are centered on their middle element
+// and pixels outside the image repeat the nearest edge pixel, as in
+// GaussianBlur.
+func ConvolveSeparable(src image.Image, kernelX, kernelY []float64) *image.RGBA {
+	clone := CloneToRGBA(src)
+	dst := image.NewRGBA(clone.Bounds())
+
+	width := clone.Bounds().Dx()
+	height := clone.Bounds().Dy()
+	tmp := make([]float64, width*height*4)
+
+	cx := len(kernelX) / 2
+	parallel(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := clone.Pix[y*clone.Stride:]
+			out := tmp[y*width*4:]
+			for x := 0; x < width; x++ {
+				var sr, sg, sb, sa float64
+				for i, k := range kernelX {
+					pos := clampInt(x+i-cx, 0, width-1) * 4
+					sr += k * float64(row[pos+0])
+					sg += k * float64(row[pos+1])
+					sb += k * float64(row[pos+2])
+					sa += k * float64(row[pos+3])
+				}
+				out[x*4+0] = sr
+				out[x*4+1] = sg
+				out[x*4+2] = sb
+				out[x*4+3] = sa
+			}
+		}
+	})
+
+	cy := len(kernelY) / 2
+	parallel(width, func(start, end int) {
+		for x := start; x < end; x++ {
+			for y := 0; y < height; y++ {
+				var sr, sg, sb, sa float64
+				for i, k := range kernelY {
+					pos := (clampInt(y+i-cy, 0, height-1)*width + x) * 4
+					sr += k * tmp[pos+0]
+					sg += k * tmp[pos+1]
+					sb += k * tmp[pos+2]
+					sa += k * tmp[pos+3]
+				}
+				setPremultiplied(dst.Pix[y*dst.Stride+x*4:], sr, sg, sb, sa)
+			}
+		}
+	})
+
+	return dst
+}
+
+// Kernel is a 2D convolution kernel whose Values are stored row by row.
+// Anchor is the element of the kernel placed over the output pixel.
+type Kernel struct {
+	Width, Height int
+	Values        []float64
+	Anchor        image.Point
+}
+
+// NewKernel returns a kernel built from rows of equal length, anchored at
+// its center.
+func NewKernel(rows [][]float64) *Kernel {
+	k := &Kernel{Height: len(rows)}
+	if k.Height > 0 {
+		k.Width = len(rows[0])
+	}
+	k.Values = make([]float64, 0, k.Width*k.Height)
+	for _, row := range rows {
+		k.Values = append(k.Values, row[:k.Width]...)
+	}
+	k.Anchor = image.Pt(k.Width/2, k.Height/2)
+	return k
+}
+
+// Sum returns the sum of all kernel values.
+func (k *Kernel) Sum() float64 {
+	var sum float64
+	for _, v := range k.Values {
+		sum += v
+	}
+	return sum
+}
+
+// ConvolveOptions controls how Convolve applies a kernel.
+type ConvolveOptions struct {
+	// Normalize divides the kernel by the sum of its values, unless it is zero.
+	Normalize bool
+	// Bias is added to the color channels after convolution, scaled by alpha.
+	Bias float64
+	// PreserveAlpha keeps the source alpha instead of convolving it, which
+	// suits edge detection kernels whose values sum to zero.
+	PreserveAlpha bool
+}
+
+// Convolve convolves src with an arbitrary 2D kernel. Pixels outside the
+// image repeat the nearest edge pixel, as in GaussianBlur. A nil opts uses
+// the zero ConvolveOptions.
+func Convolve(src image.Image, k *Kernel, opts *ConvolveOptions) *image.RGBA {
+	if opts == nil {
+		opts = &ConvolveOptions{}
+	}
+
+	clone := CloneToRGBA(src)
+	dst := image.NewRGBA(clone.Bounds())
+
+	width := clone.Bounds().Dx()
+	height := clone.Bounds().Dy()
+
+	values := k.Values
+	if sum := k.Sum(); opts.Normalize && sum != 0 {
+		values = make([]float64, len(k.Values))
+		for i, v := range k.Values {
+			values[i] = v / sum
+		}
+	}
+
+	parallel(height, func(start, end int) {
+		xoff := make([]int, k.Width)
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				for kx := range xoff {
+					xoff[kx] = clampInt(x+kx-k.Anchor.X, 0, width-1) * 4
+				}
+
+				var sr, sg, sb, sa float64
+				for ky := 0; ky < k.Height; ky++ {
+					row := clone.Pix[clampInt(y+ky-k.Anchor.Y, 0, height-1)*clone.Stride:]
+					for kx, v := range values[ky*k.Width : (ky+1)*k.Width] {
+						pos := xoff[kx]
+						sr += v * float64(row[pos+0])
+						sg += v * float64(row[pos+1])
+						sb += v * float64(row[pos+2])
+						sa += v * float64(row[pos+3])
+					}
+				}
+
+				pos := y*clone.Stride + x*4
+				if opts.PreserveAlpha {
+					sa = float64(clone.Pix[pos+3])
+				}
+				bias := opts.Bias * sa / 255
+				setPremultiplied(dst.Pix[pos:], sr+bias, sg+bias, sb+bias, sa)
+			}
+		}
+	})
+
+	return dst
+}
+
+// setPremultiplied rounds and stores a premultiplied color into pix[0:4],
+// clamping the color channels to the alpha so the result stays valid.
+func setPremultiplied(pix []uint8, r, g, b, a float64) {
+	_a := clampUint8(a)
+	pix[0] = clampUint8(math.Min(r, float64(_a)))
+	pix[1] = clampUint8(math.Min(g, float64(_a)))
+	pix[2] = clampUint8(math.Min(b, float64(_a)))
+	pix[3] = _a
+}
+
+func clampUint8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(math.Round(v))
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
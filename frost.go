@@ -0,0 +1,107 @@
+package song2
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// FrostedGlassOptions describes the panel drawn by FrostedGlass.
+type FrostedGlassOptions struct {
+	// Radius is the Gaussian blur radius of the backdrop.
+	Radius float64
+	// CornerRadius rounds the corners of the panel.
+	CornerRadius float64
+	// Tint is laid over the blurred backdrop, its alpha giving the opacity.
+	Tint color.NRGBA
+	// Noise is the amplitude of the grain added to the panel, from 0 to 1.
+	Noise float64
+}
+
+// FrostedGlass composites a translucent panel over src: the backdrop under
+// panel is blurred, tinted and grained, and the panel edges are antialiased.
+// Only the pixels under the panel and a margin around it for the blur are
+// read. A nil opts uses the zero FrostedGlassOptions.
+func FrostedGlass(src image.Image, panel image.Rectangle, opts *FrostedGlassOptions) *image.RGBA {
+	if opts == nil {
+		opts = &FrostedGlassOptions{}
+	}
+
+	dst := CloneToRGBA(src)
+
+	r := panel.Intersect(dst.Bounds())
+	if r.Empty() {
+		return dst
+	}
+
+	margin := int(math.Ceil(opts.Radius * 3))
+	area := r.Inset(-margin).Intersect(dst.Bounds())
+	backdrop := dst.SubImage(area)
+	if opts.Radius > 0 {
+		backdrop = GaussianBlur(backdrop, opts.Radius)
+	}
+	blurred := backdrop.(*image.RGBA)
+
+	ta := float64(opts.Tint.A) / 255
+	tint := [3]float64{
+		float64(opts.Tint.R) * ta,
+		float64(opts.Tint.G) * ta,
+		float64(opts.Tint.B) * ta,
+	}
+	corner := math.Min(opts.CornerRadius, math.Min(float64(panel.Dx()), float64(panel.Dy()))/2)
+
+	parallel(r.Dy(), func(start, end int) {
+		for y := r.Min.Y + start; y < r.Min.Y+end; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				coverage := roundedRectCoverage(panel, corner, float64(x)+0.5, float64(y)+0.5)
+				if coverage <= 0 {
+					continue
+				}
+
+				bpos := blurred.PixOffset(x, y)
+				a := float64(blurred.Pix[bpos+3])
+				a = a*(1-ta) + 255*ta
+
+				grain := opts.Noise * (noise(x, y) - 0.5) * a
+				var c [3]float64
+				for i := range c {
+					c[i] = float64(blurred.Pix[bpos+i])*(1-ta) + tint[i] + grain
+				}
+
+				pos := dst.PixOffset(x, y)
+				for i := range c {
+					c[i] = float64(dst.Pix[pos+i])*(1-coverage) + c[i]*coverage
+				}
+				a = float64(dst.Pix[pos+3])*(1-coverage) + a*coverage
+
+				setPremultiplied(dst.Pix[pos:], c[0], c[1], c[2], a)
+			}
+		}
+	})
+
+	return dst
+}
+
+// roundedRectCoverage returns how much of the pixel centered at (x, y) lies
+// inside r with corners rounded by radius, from 0 to 1.
+func roundedRectCoverage(r image.Rectangle, radius, x, y float64) float64 {
+	cx := float64(r.Min.X+r.Max.X) / 2
+	cy := float64(r.Min.Y+r.Max.Y) / 2
+	hx := float64(r.Dx())/2 - radius
+	hy := float64(r.Dy())/2 - radius
+
+	// Signed distance from the rounded rectangle edge, negative inside.
+	qx := math.Abs(x-cx) - hx
+	qy := math.Abs(y-cy) - hy
+	d := math.Hypot(math.Max(qx, 0), math.Max(qy, 0)) + math.Min(math.Max(qx, qy), 0) - radius
+
+	return math.Max(0, math.Min(1, 0.5-d))
+}
+
+// noise returns a repeatable pseudo random value from 0 to 1 for (x, y).
+func noise(x, y int) float64 {
+	h := uint32(x)*374761393 + uint32(y)*668265263
+	h = (h ^ (h >> 13)) * 1274126177
+	h ^= h >> 16
+	return float64(h) / math.MaxUint32
+}
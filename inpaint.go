@@ -0,0 +1,95 @@
+package song2
+
+import (
+	"image"
+	"math"
+)
+
+// Inpaint fills the holes of src marked by mask, which is read at the same
+// coordinates as src. Opaque mask pixels are replaced entirely and partially
+// transparent ones are blended with the fill. A nil mask marks no holes, and
+// src is returned unchanged.
+//
+// The holes are filled by diffusion: a normalized blur that ignores the hole
+// pixels is applied with the smallest power of two sigma that reaches the
+// middle of every hole, and then repeatedly with half the sigma, each pass
+// refining the fill where the known pixels are close enough to contribute.
+func Inpaint(src image.Image, mask *image.Alpha) *image.NRGBA {
+	b := src.Bounds()
+	chs := straightPlanes(src)
+	if mask == nil {
+		return straightToNRGBA(chs, b)
+	}
+	hole := maskPlane(mask, b)
+
+	valid := newPlane(hole.width, hole.height)
+	for i, h := range hole.pix {
+		valid.pix[i] = 1 - h
+	}
+
+	var fill [4]*plane
+	for c := range fill {
+		fill[c] = newPlane(hole.width, hole.height)
+	}
+
+	maxSigma := float64(hole.width + hole.height)
+	sigma := 1.0
+	w, tmp := diffuse(chs, valid, sigma)
+	for !covered(w, hole) && sigma < maxSigma {
+		sigma *= 2
+		w, tmp = diffuse(chs, valid, sigma)
+	}
+
+	for first := true; ; first = false {
+		for i, h := range hole.pix {
+			if h == 0 {
+				continue
+			}
+			t := float32(1)
+			if !first {
+				t = float32(math.Min(1, 2*float64(w.pix[i])))
+			}
+			for c := range fill {
+				fill[c].pix[i] += t * (tmp[c].pix[i] - fill[c].pix[i])
+			}
+		}
+
+		sigma /= 2
+		if sigma < 1 {
+			break
+		}
+		w, tmp = diffuse(chs, valid, sigma)
+	}
+
+	for i, h := range hole.pix {
+		for c := range chs {
+			chs[c].pix[i] += h * (fill[c].pix[i] - chs[c].pix[i])
+		}
+	}
+
+	return straightToNRGBA(chs, b)
+}
+
+// diffuse returns the normalized blur of chs weighted by valid, along with
+// the blurred weights.
+func diffuse(chs [4]*plane, valid *plane, sigma float64) (*plane, [4]*plane) {
+	var tmp [4]*plane
+	for c, ch := range chs {
+		tmp[c] = ch.clone()
+	}
+	w := valid.clone()
+
+	normalizedGaussian(tmp[:], w, sigma)
+
+	return w, tmp
+}
+
+// covered reports whether every hole pixel received some weight.
+func covered(w, hole *plane) bool {
+	for i, h := range hole.pix {
+		if h > 0 && w.pix[i] <= 1e-6 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package song2
+
+import (
+	"image"
+	"math"
+)
+
+// LensBlurOptions describes the aperture and highlights of LensBlur.
+type LensBlurOptions struct {
+	// Radius is the radius of the aperture in pixels.
+	Radius float64
+	// Blades is the number of sides of a polygonal aperture. Less than 3
+	// gives a disc.
+	Blades int
+	// Rotation rotates a polygonal aperture, in degrees.
+	Rotation float64
+	// Threshold is the luminance, from 0 to 1, above which pixels are
+	// brightened so that they spread into visible bokeh shapes.
+	Threshold float64
+	// Boost is how much the brightest highlights are brightened; 0 disables
+	// the highlight boost.
+	Boost float64
+	// Depth, if not nil, scales the radius per pixel: 255 uses the full
+	// radius and 0 leaves the pixel sharp. It is read at the same
+	// coordinates as src.
+	Depth *image.Gray
+}
+
+// span is the horizontal extent of one row of an aperture kernel.
+type span struct {
+	dy, x0, x1 int
+}
+
+// LensBlur simulates an out of focus lens by averaging each pixel over a disc
+// or polygon shaped aperture. The aperture is convex, so each of its rows is
+// a single span that is summed in constant time from per row prefix sums,
+// making the cost linear in the radius rather than quadratic. Pixels outside
+// the image repeat the nearest edge pixel, as in GaussianBlur.
+func LensBlur(src image.Image, opts *LensBlurOptions) *image.RGBA {
+	if opts == nil || opts.Radius <= 0 {
+		return CloneToRGBA(src)
+	}
+
+	b := src.Bounds()
+	ps := premultipliedPlanes(src)
+	width, height := b.Dx(), b.Dy()
+
+	if opts.Boost > 0 {
+		boostHighlights(ps, opts.Threshold, opts.Boost)
+	}
+
+	// Prefix sums of every row, with one leading zero per row.
+	var sums [4][]float32
+	for c, p := range ps {
+		sums[c] = make([]float32, (width+1)*height)
+		s := sums[c]
+		parallel(height, func(start, end int) {
+			for y := start; y < end; y++ {
+				row := s[y*(width+1):]
+				for x := 0; x < width; x++ {
+					row[x+1] = row[x] + p.pix[y*width+x]
+				}
+			}
+		})
+	}
+
+	maxRadius := int(math.Ceil(opts.Radius))
+	kernels := make([][]span, maxRadius+1)
+	for r := range kernels {
+		kernels[r] = apertureSpans(math.Min(float64(r), opts.Radius), opts.Blades, opts.Rotation)
+	}
+
+	dst := image.NewRGBA(b)
+	parallel(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				r := maxRadius
+				if opts.Depth != nil {
+					d := float64(opts.Depth.GrayAt(b.Min.X+x, b.Min.Y+y).Y) / 255
+					r = int(math.Round(opts.Radius * d))
+				}
+
+				var acc [4]float64
+				var count int
+				for _, s := range kernels[r] {
+					yy := clampInt(y+s.dy, 0, height-1)
+					for c := range acc {
+						acc[c] += rowSum(sums[c][yy*(width+1):], ps[c].pix[yy*width:], width, x+s.x0, x+s.x1)
+					}
+					count += s.x1 - s.x0 + 1
+				}
+
+				n := float64(count)
+				setPremultiplied(dst.Pix[y*dst.Stride+x*4:], acc[0]/n, acc[1]/n, acc[2]/n, acc[3]/n)
+			}
+		}
+	})
+
+	return dst
+}
+
+// rowSum returns the sum of row[x0..x1], repeating the first and last values
+// of the row outside it. sum holds the prefix sums of row.
+func rowSum(sum, row []float32, width, x0, x1 int) float64 {
+	var s float64
+	if x0 < 0 {
+		s += float64(-x0) * float64(row[0])
+		x0 = 0
+	}
+	if x1 > width-1 {
+		s += float64(x1-width+1) * float64(row[width-1])
+		x1 = width - 1
+	}
+	if x0 <= x1 {
+		s += float64(sum[x1+1] - sum[x0])
+	}
+	return s
+}
+
+// apertureSpans returns the rows of a disc, or of a regular polygon with the
+// given number of blades, of radius r centered on the origin. A pixel belongs
+// to the aperture if its center does.
+func apertureSpans(r float64, blades int, rotation float64) []span {
+	ir := int(math.Ceil(r))
+	if ir == 0 {
+		return []span{{0, 0, 0}}
+	}
+
+	var verts [][2]float64
+	if blades >= 3 {
+		rot := rotation * math.Pi / 180
+		for k := 0; k < blades; k++ {
+			sin, cos := math.Sincos(rot + 2*math.Pi*float64(k)/float64(blades))
+			verts = append(verts, [2]float64{r * cos, r * sin})
+		}
+	}
+
+	var spans []span
+	for dy := -ir; dy <= ir; dy++ {
+		y := float64(dy)
+		var left, right float64
+		if verts == nil {
+			if math.Abs(y) > r {
+				continue
+			}
+			right = math.Sqrt(r*r - y*y)
+			left = -right
+		} else {
+			left, right = math.Inf(1), math.Inf(-1)
+			for k := range verts {
+				a, c := verts[k], verts[(k+1)%len(verts)]
+				if (a[1] > y) == (c[1] > y) && a[1] != y {
+					continue
+				}
+				x := a[0]
+				if c[1] != a[1] {
+					x += (y - a[1]) * (c[0] - a[0]) / (c[1] - a[1])
+				}
+				left, right = math.Min(left, x), math.Max(right, x)
+			}
+			// Rows past the top and bottom vertices cross no edge.
+			if math.IsInf(left, 1) {
+				continue
+			}
+		}
+
+		x0, x1 := int(math.Ceil(left-1e-9)), int(math.Floor(right+1e-9))
+		if x0 <= x1 {
+			spans = append(spans, span{dy, x0, x1})
+		}
+	}
+
+	if spans == nil {
+		spans = []span{{0, 0, 0}}
+	}
+	return spans
+}
+
+// boostHighlights brightens the premultiplied color of pixels whose luminance
+// is above threshold, by up to 1+boost times for white.
+func boostHighlights(ps [4]*plane, threshold, boost float64) {
+	threshold = math.Max(0, math.Min(threshold, 0.999))
+	parallel(ps[3].height, func(start, end int) {
+		for i := start * ps[3].width; i < end*ps[3].width; i++ {
+			a := float64(ps[3].pix[i])
+			if a == 0 {
+				continue
+			}
+			l := (0.299*float64(ps[0].pix[i]) + 0.587*float64(ps[1].pix[i]) + 0.114*float64(ps[2].pix[i])) / a
+			if l <= threshold {
+				continue
+			}
+			t := (l - threshold) / (1 - threshold)
+			f := float32(1 + boost*t*t)
+			for c := 0; c < 3; c++ {
+				ps[c].pix[i] *= f
+			}
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package song2
+
+import (
+	"image"
+)
+
+// FeatherMask softens the edges of mask in place. If grow is positive the
+// mask is first dilated by grow pixels, and if it is negative eroded by -grow
+// pixels; it is then Gaussian blurred with sigma.
+func FeatherMask(mask *image.Alpha, sigma float64, grow int) {
+	switch {
+	case grow > 0:
+		morphAlpha(mask, grow, grow, true)
+	case grow < 0:
+		morphAlpha(mask, -grow, -grow, false)
+	}
+
+	if sigma <= 0 {
+		return
+	}
+
+	b := mask.Bounds()
+	p := maskPlane(mask, b)
+	p.gaussianBlur(sigma, nil)
+	for y := 0; y < p.height; y++ {
+		row := mask.Pix[y*mask.Stride:]
+		for x := 0; x < p.width; x++ {
+			row[x] = clampUint8(float64(p.pix[y*p.width+x]) * 255)
+		}
+	}
+}
+
+// Dilate replaces each channel of every pixel with its maximum over a
+// rectangle of radius rx horizontally and ry vertically, in constant time per
+// pixel whatever the radii.
+func Dilate(src image.Image, rx, ry int) *image.RGBA {
+	dst := CloneToRGBA(src)
+	morphRGBA(dst, rx, ry, true)
+	return dst
+}
+
+// Erode replaces each channel of every pixel with its minimum over a
+// rectangle of radius rx horizontally and ry vertically.
+func Erode(src image.Image, rx, ry int) *image.RGBA {
+	dst := CloneToRGBA(src)
+	morphRGBA(dst, rx, ry, false)
+	return dst
+}
+
+// Open erodes and then dilates src, removing bright details smaller than the
+// rectangle.
+func Open(src image.Image, rx, ry int) *image.RGBA {
+	dst := CloneToRGBA(src)
+	morphRGBA(dst, rx, ry, false)
+	morphRGBA(dst, rx, ry, true)
+	return dst
+}
+
+// Close dilates and then erodes src, filling dark details smaller than the
+// rectangle.
+func Close(src image.Image, rx, ry int) *image.RGBA {
+	dst := CloneToRGBA(src)
+	morphRGBA(dst, rx, ry, true)
+	morphRGBA(dst, rx, ry, false)
+	return dst
+}
+
+// DilateGray is the grayscale version of Dilate.
+func DilateGray(src *image.Gray, rx, ry int) *image.Gray {
+	dst := cloneGray(src)
+	morph(dst.Pix, 1, dst.Stride, dst.Bounds().Dx(), dst.Bounds().Dy(), rx, ry, true)
+	return dst
+}
+
+// ErodeGray is the grayscale version of Erode.
+func ErodeGray(src *image.Gray, rx, ry int) *image.Gray {
+	dst := cloneGray(src)
+	morph(dst.Pix, 1, dst.Stride, dst.Bounds().Dx(), dst.Bounds().Dy(), rx, ry, false)
+	return dst
+}
+
+// OpenGray is the grayscale version of Open.
+func OpenGray(src *image.Gray, rx, ry int) *image.Gray {
+	return DilateGray(ErodeGray(src, rx, ry), rx, ry)
+}
+
+// CloseGray is the grayscale version of Close.
+func CloseGray(src *image.Gray, rx, ry int) *image.Gray {
+	return ErodeGray(DilateGray(src, rx, ry), rx, ry)
+}
+
+func cloneGray(src *image.Gray) *image.Gray {
+	dst := image.NewGray(src.Bounds())
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], src.Pix[y*src.Stride:])
+	}
+	return dst
+}
+
+// morphRGBA dilates (or erodes) each channel of img in place.
+func morphRGBA(img *image.RGBA, rx, ry int, dilate bool) {
+	if img.Bounds().Empty() {
+		return
+	}
+	for c := 0; c < 4; c++ {
+		morph(img.Pix[c:], 4, img.Stride, img.Bounds().Dx(), img.Bounds().Dy(), rx, ry, dilate)
+	}
+}
+
+// morphAlpha dilates (or erodes) mask in place.
+func morphAlpha(mask *image.Alpha, rx, ry int, dilate bool) {
+	morph(mask.Pix, 1, mask.Stride, mask.Bounds().Dx(), mask.Bounds().Dy(), rx, ry, dilate)
+}
+
+// morph dilates (or erodes) in place the width by height samples stored at
+// pix[y*stride+x*step], with a rectangle of radius rx horizontally and ry
+// vertically. Like boxBlur it runs a parallel pass over the rows and then one
+// over the columns.
+func morph(pix []uint8, step, stride, width, height, rx, ry int, dilate bool) {
+	if rx < 0 {
+		rx = 0
+	}
+	if ry < 0 {
+		ry = 0
+	}
+	tmp := make([]uint8, width*height)
+
+	parallel(height, func(start, end int) {
+		buf := make([]uint8, 3*(width+2*rx))
+		for y := start; y < end; y++ {
+			slidingExtreme(pix[y*stride:], step, tmp[y*width:], 1, width, rx, dilate, buf)
+		}
+	})
+	parallel(width, func(start, end int) {
+		buf := make([]uint8, 3*(height+2*ry))
+		for x := start; x < end; x++ {
+			slidingExtreme(tmp[x:], width, pix[x*step:], stride, height, ry, dilate, buf)
+		}
+	})
+}
+
+// slidingExtreme writes the maximum (or minimum, if dilate is false) of n
+// values of src over a window of radius r into dst. Consecutive values are
+// sstride apart in src and dstride apart in dst, and values past the ends of
+// the line repeat the first and last value.
+//
+// It uses the van Herk/Gil-Werman algorithm: the padded line is split into
+// blocks of the window size, and running extremes from the start and the end
+// of each block give the extreme of any window with one comparison, whatever
+// the radius. buf must hold at least 3*(n+2*r) values.
+func slidingExtreme(src []uint8, sstride int, dst []uint8, dstride, n, r int, dilate bool, buf []uint8) {
+	if n == 0 {
+		return
+	}
+
+	size := 2*r + 1
+	padded := n + 2*r
+	line, g, h := buf[:padded], buf[padded:2*padded], buf[2*padded:3*padded]
+
+	for i := range line {
+		line[i] = src[clampInt(i-r, 0, n-1)*sstride]
+	}
+
+	pick := func(a, b uint8) uint8 {
+		if (a > b) == dilate {
+			return a
+		}
+		return b
+	}
+
+	for i, v := range line {
+		if i%size == 0 {
+			g[i] = v
+		} else {
+			g[i] = pick(g[i-1], v)
+		}
+	}
+	for i := padded - 1; i >= 0; i-- {
+		if i == padded-1 || (i+1)%size == 0 {
+			h[i] = line[i]
+		} else {
+			h[i] = pick(h[i+1], line[i])
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		dst[i*dstride] = pick(h[i], g[i+size-1])
+	}
+}
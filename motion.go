@@ -0,0 +1,117 @@
+package song2
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// MotionBlur blurs src along a line of length pixels, rotated angle degrees
+// counterclockwise from the x axis. Horizontal and vertical lines of odd
+// length use the sliding window box passes; other lines are rasterized into
+// a sparse antialiased kernel, whose cost grows linearly with length. Lengths
+// beyond twice the image diagonal only repeat edge pixels and are capped.
+func MotionBlur(src image.Image, angle, length float64) *image.RGBA {
+	b := src.Bounds()
+	length = math.Min(length, 2*math.Hypot(float64(b.Dx()), float64(b.Dy())))
+	if length <= 1 {
+		return CloneToRGBA(src)
+	}
+
+	angle = math.Mod(angle, 180)
+	if angle < 0 {
+		angle += 180
+	}
+
+	if l := math.Round(length); l == length && int(l)%2 == 1 && (angle == 0 || angle == 90) {
+		clone := CloneToRGBA(src)
+		dst := image.NewRGBA(clone.Bounds())
+
+		width := clone.Bounds().Dx()
+		height := clone.Bounds().Dy()
+
+		r := (int(l) - 1) / 2
+		if angle == 0 {
+			boxBlurParallel(dirX, height, clone, dst, clampRadius(r, width), nil)
+		} else {
+			boxBlurParallel(dirY, width, clone, dst, clampRadius(r, height), nil)
+		}
+		return dst
+	}
+
+	return convolveTaps(CloneToRGBA(src), motionTaps(angle, length))
+}
+
+// tap is one non-zero weight of a sparse kernel, at an offset from the pixel.
+type tap struct {
+	dx, dy int
+	w      float64
+}
+
+// motionTaps rasterizes a line segment centered on the origin by splatting
+// closely spaced samples along it with bilinear weights. Only the pixels the
+// line touches are kept, so the cost per pixel grows linearly with length.
+// The weights sum to 1.
+func motionTaps(angle, length float64) []tap {
+	rad := angle * math.Pi / 180
+	dx, dy := math.Cos(rad), -math.Sin(rad)
+
+	weights := map[image.Point]float64{}
+	n := int(math.Ceil(length * 4))
+	for i := 0; i < n; i++ {
+		t := -length/2 + (float64(i)+0.5)*length/float64(n)
+		x, y := t*dx, t*dy
+
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := x-x0, y-y0
+		ix, iy := int(x0), int(y0)
+
+		weights[image.Pt(ix, iy)] += (1 - fx) * (1 - fy)
+		weights[image.Pt(ix+1, iy)] += fx * (1 - fy)
+		weights[image.Pt(ix, iy+1)] += (1 - fx) * fy
+		weights[image.Pt(ix+1, iy+1)] += fx * fy
+	}
+
+	taps := make([]tap, 0, len(weights))
+	for p, w := range weights {
+		if w > 0 {
+			taps = append(taps, tap{p.X, p.Y, w / float64(n)})
+		}
+	}
+	// Sort the taps so that the sums, and so the output, do not depend on
+	// the map order.
+	sort.Slice(taps, func(i, j int) bool {
+		if taps[i].dy != taps[j].dy {
+			return taps[i].dy < taps[j].dy
+		}
+		return taps[i].dx < taps[j].dx
+	})
+	return taps
+}
+
+// convolveTaps returns src convolved with taps, repeating the edge pixels
+// outside of it.
+func convolveTaps(src *image.RGBA, taps []tap) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	width := src.Bounds().Dx()
+	height := src.Bounds().Dy()
+
+	parallel(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				var sr, sg, sb, sa float64
+				for _, t := range taps {
+					pos := clampInt(y+t.dy, 0, height-1)*src.Stride + clampInt(x+t.dx, 0, width-1)*4
+					sr += t.w * float64(src.Pix[pos+0])
+					sg += t.w * float64(src.Pix[pos+1])
+					sb += t.w * float64(src.Pix[pos+2])
+					sa += t.w * float64(src.Pix[pos+3])
+				}
+
+				setPremultiplied(dst.Pix[y*dst.Stride+x*4:], sr, sg, sb, sa)
+			}
+		}
+	})
+
+	return dst
+}
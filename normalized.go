@@ -0,0 +1,119 @@
+package song2
+
+import (
+	"image"
+)
+
+// NormalizedBlur blurs src with a Gaussian of radius r as a normalized
+// convolution: every pixel contributes in proportion to its weight and the
+// result is divided by the sum of the weights it received, so transparent or
+// invalid pixels do not pull their color into their neighbors.
+//
+// If mask is nil, pixels are weighted by their alpha, and the returned alpha
+// is the blurred alpha. Otherwise pixels are weighted by mask, which is read
+// at the same coordinates as src, and all four channels are normalized;
+// pixels outside mask have zero weight.
+func NormalizedBlur(src image.Image, r float64, mask *image.Alpha) *image.NRGBA {
+	b := src.Bounds()
+	chs := straightPlanes(src)
+
+	var w *plane
+	if mask == nil {
+		w = newPlane(b.Dx(), b.Dy())
+		for i, a := range chs[3].pix {
+			w.pix[i] = a / 255
+		}
+		chs[3] = nil
+	} else {
+		w = maskPlane(mask, b)
+	}
+
+	normalizedGaussian(chs[:], w, r)
+
+	if mask == nil {
+		chs[3] = w
+		for i, v := range w.pix {
+			w.pix[i] = v * 255
+		}
+	}
+
+	return straightToNRGBA(chs, b)
+}
+
+// normalizedGaussian replaces each non-nil channel with its Gaussian blur
+// weighted by w, divided by the blurred weights, and replaces w with its
+// blur. Channels are zero where the blurred weight is zero.
+func normalizedGaussian(chs []*plane, w *plane, sigma float64) {
+	for _, ch := range chs {
+		if ch == nil {
+			continue
+		}
+		for i, v := range ch.pix {
+			ch.pix[i] = v * w.pix[i]
+		}
+		ch.gaussianBlur(sigma, nil)
+	}
+
+	w.gaussianBlur(sigma, nil)
+
+	for _, ch := range chs {
+		if ch == nil {
+			continue
+		}
+		for i, v := range ch.pix {
+			if w.pix[i] > 1e-6 {
+				ch.pix[i] = v / w.pix[i]
+			} else {
+				ch.pix[i] = 0
+			}
+		}
+	}
+}
+
+// straightPlanes splits src into un-premultiplied red, green, blue and alpha
+// planes in 0-255 units.
+func straightPlanes(src image.Image) [4]*plane {
+	ps := premultipliedPlanes(src)
+	for i, a := range ps[3].pix {
+		for c := 0; c < 3; c++ {
+			if a > 0 {
+				ps[c].pix[i] = ps[c].pix[i] / a * 255
+			} else {
+				ps[c].pix[i] = 0
+			}
+		}
+	}
+	return ps
+}
+
+// maskPlane returns the values of mask from 0 to 1 over the rectangle b.
+// Pixels of b outside mask are zero.
+func maskPlane(mask *image.Alpha, b image.Rectangle) *plane {
+	p := newPlane(b.Dx(), b.Dy())
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			p.pix[y*p.width+x] = float32(mask.AlphaAt(b.Min.X+x, b.Min.Y+y).A) / 255
+		}
+	}
+	return p
+}
+
+// straightToNRGBA rounds un-premultiplied planes in 0-255 units into an
+// image with bounds b.
+func straightToNRGBA(ps [4]*plane, b image.Rectangle) *image.NRGBA {
+	dst := image.NewNRGBA(b)
+	width := ps[3].width
+
+	parallel(ps[3].height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := dst.Pix[y*dst.Stride:]
+			for x := 0; x < width; x++ {
+				for c, p := range ps {
+					row[x*4+c] = clampUint8(float64(p.pix[y*width+x]))
+				}
+			}
+		}
+	})
+
+	return dst
+}
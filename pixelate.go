@@ -0,0 +1,80 @@
+package song2
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Pixelate replaces src with blocks of size by size pixels filled with their
+// average color, aligned to the top left corner of src. If sigma is positive
+// the mosaic is then Gaussian blurred with it.
+func Pixelate(src image.Image, size int, sigma float64) *image.RGBA {
+	return PixelateRegion(src, src.Bounds(), size, sigma)
+}
+
+// PixelateRegion is like Pixelate but only changes the pixels of src inside
+// r, with blocks aligned to r.Min. Pixels outside r neither change nor leak
+// into the region.
+func PixelateRegion(src image.Image, r image.Rectangle, size int, sigma float64) *image.RGBA {
+	dst := CloneToRGBA(src)
+
+	r = r.Intersect(dst.Bounds())
+	if r.Empty() {
+		return dst
+	}
+
+	sub := dst.SubImage(r).(*image.RGBA)
+	if size > 1 {
+		mosaic(sub, size)
+	}
+	if sigma > 0 {
+		draw.Draw(dst, r, GaussianBlur(sub, sigma), r.Min, draw.Src)
+	}
+
+	return dst
+}
+
+// mosaic fills each block of img in place with its average color.
+func mosaic(img *image.RGBA, size int) {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+
+	parallel((height+size-1)/size, func(start, end int) {
+		for by := start; by < end; by++ {
+			y0, y1 := by*size, by*size+size
+			if y1 > height {
+				y1 = height
+			}
+
+			for x0 := 0; x0 < width; x0 += size {
+				x1 := x0 + size
+				if x1 > width {
+					x1 = width
+				}
+
+				var sum [4]int
+				for y := y0; y < y1; y++ {
+					row := img.Pix[y*img.Stride:]
+					for x := x0; x < x1; x++ {
+						for c := range sum {
+							sum[c] += int(row[x*4+c])
+						}
+					}
+				}
+
+				n := float64((x1 - x0) * (y1 - y0))
+				var avg [4]uint8
+				for c := range avg {
+					avg[c] = clampUint8(float64(sum[c]) / n)
+				}
+
+				for y := y0; y < y1; y++ {
+					row := img.Pix[y*img.Stride:]
+					for x := x0; x < x1; x++ {
+						copy(row[x*4:x*4+4], avg[:])
+					}
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package song2
+
+import (
+	"image"
+	"image/draw"
+)
+
+// plane is a single channel of float values, used by filters that need more
+// precision than the 8 bit box passes.
+type plane struct {
+	width, height int
+	pix           []float32
+}
+
+func newPlane(width, height int) *plane {
+	return &plane{
+		width:  width,
+		height: height,
+		pix:    make([]float32, width*height),
+	}
+}
+
+func (p *plane) clone() *plane {
+	c := newPlane(p.width, p.height)
+	copy(c.pix, p.pix)
+	return c
+}
+
+// premultipliedPlanes splits src into premultiplied red, green, blue and
+// alpha planes in 0-255 units, keeping the 16 bit precision of src.
+func premultipliedPlanes(src image.Image) [4]*plane {
+	b := src.Bounds()
+	rgba := image.NewRGBA64(b)
+	draw.Draw(rgba, b, src, b.Min, draw.Src)
+
+	width, height := b.Dx(), b.Dy()
+	var ps [4]*plane
+	for c := range ps {
+		ps[c] = newPlane(width, height)
+	}
+
+	parallel(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := rgba.Pix[y*rgba.Stride:]
+			for x := 0; x < width; x++ {
+				for c, p := range ps {
+					v := uint16(row[x*8+c*2])<<8 | uint16(row[x*8+c*2+1])
+					p.pix[y*width+x] = float32(v) / 257
+				}
+			}
+		}
+	})
+
+	return ps
+}
+
+// planesToNRGBA un-premultiplies premultiplied planes in 0-255 units into a
+// straight alpha image, dividing before rounding so that the color of nearly
+// transparent pixels keeps its precision.
+func planesToNRGBA(ps [4]*plane, b image.Rectangle) *image.NRGBA {
+	dst := image.NewNRGBA(b)
+	width := ps[3].width
+
+	parallel(ps[3].height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := dst.Pix[y*dst.Stride:]
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				a := float64(ps[3].pix[i])
+				_a := clampUint8(a)
+				if _a == 0 {
+					continue
+				}
+				for c := 0; c < 3; c++ {
+					row[x*4+c] = clampUint8(float64(ps[c].pix[i]) / a * 255)
+				}
+				row[x*4+3] = _a
+			}
+		}
+	})
+
+	return dst
+}
+
+// gaussianBlur approximates a Gaussian blur of p in place with three box
+// blurs, as GaussianBlur does, and reports the rows and columns done to pr.
+func (p *plane) gaussianBlur(sigma float64, pr *progress) {
+	tmp := newPlane(p.width, p.height)
+	for _, b := range BoxesForGauss(sigma, 3) {
+		p.boxBlur(tmp, (b-1)/2, (b-1)/2, pr)
+	}
+}
+
+// boxBlur blurs p in place, using tmp as the intermediate buffer, and reports
+// the rows and columns done to pr.
+func (p *plane) boxBlur(tmp *plane, rx, ry int, pr *progress) {
+	parallel(p.height, func(start, end int) {
+		pr.each(start, end, func(start, end int) {
+			for y := start; y < end; y++ {
+				boxBlurLine(p.pix[y*p.width:], tmp.pix[y*p.width:], p.width, 1, rx)
+			}
+		})
+	})
+	parallel(p.width, func(start, end int) {
+		pr.each(start, end, func(start, end int) {
+			for x := start; x < end; x++ {
+				boxBlurLine(tmp.pix[x:], p.pix[x:], p.height, p.width, ry)
+			}
+		})
+	})
+}
+
+// boxBlurLine writes the box blur of n values of src, spaced stride apart,
+// into dst with the sliding window sum of BoxBlurHorizontal. Values outside
+// the line repeat the first and last value.
+func boxBlurLine(src, dst []float32, n, stride, r int) {
+	if n == 0 {
+		return
+	}
+	at := func(i int) float64 {
+		return float64(src[clampInt(i, 0, n-1)*stride])
+	}
+
+	iarr := 1.0 / float64(r+r+1)
+
+	var val float64
+	for j := -r; j <= r; j++ {
+		val += at(j)
+	}
+
+	for i := 0; i < n; i++ {
+		dst[i*stride] = float32(val * iarr)
+		val += at(i+r+1) - at(i-r)
+	}
+}
@@ -0,0 +1,72 @@
+package song2
+
+import "sync"
+
+// progressChunk is the number of rows or columns blurred between two
+// progress reports.
+const progressChunk = 64
+
+// progress counts the rows and columns blurred across all the passes of a
+// blur and reports them to fn, and skips the remaining ones once cancel is
+// closed. A nil *progress reports nothing and never cancels.
+type progress struct {
+	mu     sync.Mutex
+	done   int
+	total  int
+	fn     func(done, total int)
+	cancel <-chan struct{}
+}
+
+// newProgress returns the progress of opts out of total lines, or nil if
+// opts neither reports progress nor can cancel.
+func newProgress(opts *BlurOptions, total int) *progress {
+	if opts == nil || (opts.Progress == nil && opts.Done == nil) {
+		return nil
+	}
+	return &progress{total: total, fn: opts.Progress, cancel: opts.Done}
+}
+
+// each calls fn for the lines from start to end in chunks of progressChunk,
+// reporting every chunk once it is done.
+func (pr *progress) each(start, end int, fn func(start, end int)) {
+	if pr == nil {
+		fn(start, end)
+		return
+	}
+
+	for s := start; s < end; s += progressChunk {
+		if pr.stopped() {
+			return
+		}
+
+		e := s + progressChunk
+		if e > end {
+			e = end
+		}
+		fn(s, e)
+		pr.add(e - s)
+	}
+}
+
+// stopped reports whether the blur was cancelled.
+func (pr *progress) stopped() bool {
+	if pr == nil {
+		return false
+	}
+	select {
+	case <-pr.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pr *progress) add(n int) {
+	if pr.fn == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.done += n
+	pr.fn(pr.done, pr.total)
+}
@@ -0,0 +1,88 @@
+package song2
+
+import (
+	"image"
+	"math"
+)
+
+// ZoomBlur blurs src along lines toward center. strength is the fraction of
+// the distance to center covered by the blur, from 0 to 1, and samples is the
+// number of taps taken per pixel.
+func ZoomBlur(src image.Image, center image.Point, strength float64, samples int) *image.RGBA {
+	return radialBlur(src, center, samples, func(dx, dy, t float64) (float64, float64) {
+		s := 1 - strength*t
+		return dx * s, dy * s
+	})
+}
+
+// SpinBlur blurs src along circles around center, spreading each pixel over
+// an arc of angle degrees. samples is the number of taps taken per pixel.
+func SpinBlur(src image.Image, center image.Point, angle float64, samples int) *image.RGBA {
+	rad := angle * math.Pi / 180
+	return radialBlur(src, center, samples, func(dx, dy, t float64) (float64, float64) {
+		sin, cos := math.Sincos(rad * (t - 0.5))
+		return dx*cos - dy*sin, dx*sin + dy*cos
+	})
+}
+
+// radialBlur averages samples taps per pixel. tap maps the offset of a pixel
+// from center to the offset of the tap at t, which runs from 0 to 1.
+func radialBlur(src image.Image, center image.Point, samples int, tap func(dx, dy, t float64) (float64, float64)) *image.RGBA {
+	clone := CloneToRGBA(src)
+	if samples < 2 {
+		return clone
+	}
+
+	bounds := clone.Bounds()
+	dst := image.NewRGBA(bounds)
+
+	cx := float64(center.X - bounds.Min.X)
+	cy := float64(center.Y - bounds.Min.Y)
+	n := float64(samples)
+
+	parallel(bounds.Dy(), func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				dx, dy := float64(x)-cx, float64(y)-cy
+
+				var sr, sg, sb, sa float64
+				for i := 0; i < samples; i++ {
+					tx, ty := tap(dx, dy, float64(i)/(n-1))
+					r, g, b, a := sampleBilinear(clone, cx+tx, cy+ty)
+					sr += r
+					sg += g
+					sb += b
+					sa += a
+				}
+
+				setPremultiplied(dst.Pix[y*dst.Stride+x*4:], sr/n, sg/n, sb/n, sa/n)
+			}
+		}
+	})
+
+	return dst
+}
+
+// sampleBilinear interpolates the premultiplied color of img at (x, y),
+// relative to its bounds, repeating edge pixels outside the image.
+func sampleBilinear(img *image.RGBA, x, y float64) (r, g, b, a float64) {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+
+	xs := [2]int{clampInt(int(x0), 0, width-1) * 4, clampInt(int(x0)+1, 0, width-1) * 4}
+	ys := [2]int{clampInt(int(y0), 0, height-1) * img.Stride, clampInt(int(y0)+1, 0, height-1) * img.Stride}
+	ws := [4]float64{(1 - fx) * (1 - fy), fx * (1 - fy), (1 - fx) * fy, fx * fy}
+
+	for i, w := range ws {
+		pos := ys[i/2] + xs[i%2]
+		r += w * float64(img.Pix[pos+0])
+		g += w * float64(img.Pix[pos+1])
+		b += w * float64(img.Pix[pos+2])
+		a += w * float64(img.Pix[pos+3])
+	}
+
+	return r, g, b, a
+}
@@ -0,0 +1,103 @@
+package song2
+
+import (
+	"image"
+	"math"
+)
+
+// MedianFilter replaces each channel of every pixel with the median of its
+// (2*radius+1) square neighborhood, which removes salt and pepper noise.
+func MedianFilter(src image.Image, radius int) *image.RGBA {
+	return RankFilter(src, radius, 0.5)
+}
+
+// RankFilter replaces each channel of every pixel with a percentile of its
+// (2*radius+1) square neighborhood: rank 0 is the minimum, 0.5 the median
+// and 1 the maximum. Pixels outside the image repeat the nearest edge pixel.
+//
+// Like the sliding window sum of BoxBlurHorizontal, it keeps a histogram of
+// each column of the window that moves down one row at a time, and a
+// histogram of the window that moves right one column at a time by adding and
+// removing column histograms, so the cost per pixel does not depend on the
+// radius.
+func RankFilter(src image.Image, radius int, rank float64) *image.RGBA {
+	clone := CloneToRGBA(src)
+	if radius <= 0 {
+		return clone
+	}
+
+	b := clone.Bounds()
+	dst := image.NewRGBA(b)
+	width, height := b.Dx(), b.Dy()
+
+	rank = math.Max(0, math.Min(rank, 1))
+	size := 2*radius + 1
+	k := int32(math.Round(rank * float64(size*size-1)))
+
+	parallel(height, func(start, end int) {
+		cols := make([][4][256]uint16, width)
+		update := func(y int, add bool) {
+			row := clone.Pix[clampInt(y, 0, height-1)*clone.Stride:]
+			for x := range cols {
+				for c := 0; c < 4; c++ {
+					if add {
+						cols[x][c][row[x*4+c]]++
+					} else {
+						cols[x][c][row[x*4+c]]--
+					}
+				}
+			}
+		}
+
+		for dy := -radius; dy <= radius; dy++ {
+			update(start+dy, true)
+		}
+
+		var hist [4][256]int32
+		for y := start; y < end; y++ {
+			if y > start {
+				update(y-radius-1, false)
+				update(y+radius, true)
+			}
+
+			hist = [4][256]int32{}
+			for dx := -radius; dx <= radius; dx++ {
+				addColumn(&hist, &cols[clampInt(dx, 0, width-1)], 1)
+			}
+
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < width; x++ {
+				if x > 0 {
+					addColumn(&hist, &cols[clampInt(x-radius-1, 0, width-1)], -1)
+					addColumn(&hist, &cols[clampInt(x+radius, 0, width-1)], 1)
+				}
+				for c := 0; c < 4; c++ {
+					out[x*4+c] = selectRank(&hist[c], k)
+				}
+			}
+		}
+	})
+
+	return dst
+}
+
+func addColumn(hist *[4][256]int32, col *[4][256]uint16, sign int32) {
+	for c := range hist {
+		for v, n := range col[c] {
+			hist[c][v] += sign * int32(n)
+		}
+	}
+}
+
+// selectRank returns the value at index k of the sorted values counted by
+// hist.
+func selectRank(hist *[256]int32, k int32) uint8 {
+	var n int32
+	for v, count := range hist {
+		n += count
+		if n > k {
+			return uint8(v)
+		}
+	}
+	return 255
+}
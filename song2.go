@@ -12,18 +12,69 @@ import (
 )
 
 func GaussianBlur(src image.Image, r float64) *image.RGBA {
+	return GaussianBlurWithOptions(src, r, nil)
+}
+
+// BlurOptions holds the optional settings of GaussianBlurWithOptions and
+// GaussianBlurNRGBAWithOptions.
+type BlurOptions struct {
+	// Progress, if not nil, is called as the blur goes with the number of
+	// rows and columns blurred so far and their total over all the box blur
+	// passes. Calls do not overlap but may come from different goroutines.
+	Progress func(done, total int)
+	// Done, if not nil, stops the blur early once it is closed, e.g. with
+	// the Done channel of a context. The image returned is then incomplete
+	// and should be discarded.
+	Done <-chan struct{}
+}
+
+// GaussianBlurWithOptions is like GaussianBlur with optional settings. A nil
+// opts is the same as GaussianBlur.
+func GaussianBlurWithOptions(src image.Image, r float64, opts *BlurOptions) *image.RGBA {
 	clone := CloneToRGBA(src)
 	dst := CloneToRGBA(src)
 
 	bxs := BoxesForGauss(r, 3)
 
+	bounds := src.Bounds()
+	pr := newProgress(opts, len(bxs)*(bounds.Dx()+bounds.Dy()))
+
 	for _, b := range bxs {
-		boxBlur(clone, dst, (b-1)/2)
+		boxBlur(clone, dst, (b-1)/2, (b-1)/2, pr)
 	}
 
 	return dst
 }
 
+// GaussianBlurNRGBA is like GaussianBlur but returns a straight alpha image.
+// The blur runs on float channels and is un-premultiplied before rounding,
+// so semi-transparent edges do not lose color precision or darken.
+func GaussianBlurNRGBA(src image.Image, r float64) *image.NRGBA {
+	return GaussianBlurNRGBAWithOptions(src, r, nil)
+}
+
+// GaussianBlurNRGBAWithOptions is like GaussianBlurNRGBA with optional
+// settings. Each of the four channels counts its own rows and columns
+// towards the progress.
+func GaussianBlurNRGBAWithOptions(src image.Image, r float64, opts *BlurOptions) *image.NRGBA {
+	ps := premultipliedPlanes(src)
+
+	b := src.Bounds()
+	pr := newProgress(opts, len(ps)*len(BoxesForGauss(r, 3))*(b.Dx()+b.Dy()))
+
+	for _, p := range ps {
+		if pr.stopped() {
+			return image.NewNRGBA(b)
+		}
+		p.gaussianBlur(r, pr)
+	}
+	if pr.stopped() {
+		return image.NewNRGBA(b)
+	}
+
+	return planesToNRGBA(ps, src.Bounds())
+}
+
 type Direction int
 
 const (
@@ -31,17 +82,59 @@ const (
 	dirY
 )
 
-func boxBlur(src, dst *image.RGBA, r int) {
+// BoxBlur blurs src with a box of radius rx horizontally and ry vertically.
+// Radii larger than the image are clamped to it.
+func BoxBlur(src image.Image, rx, ry int) *image.RGBA {
+	tmp := image.NewRGBA(src.Bounds())
+	dst := CloneToRGBA(src)
+
+	boxBlur(tmp, dst, rx, ry, nil)
+
+	return dst
+}
+
+// boxBlur blurs dst in place, using src as the intermediate buffer, and
+// reports the rows and columns done to pr.
+func boxBlur(src, dst *image.RGBA, rx, ry int, pr *progress) {
 	height := src.Bounds().Max.Y - src.Bounds().Min.Y
 	width := src.Bounds().Max.X - src.Bounds().Min.X
 
-	boxBlurParallel(dirX, height, dst, src, r)
-	boxBlurParallel(dirY, width, src, dst, r)
+	boxBlurParallel(dirX, height, dst, src, clampRadius(rx, width), pr)
+	boxBlurParallel(dirY, width, src, dst, clampRadius(ry, height), pr)
+}
+
+// clampRadius limits r so that the box window fits in length pixels.
+func clampRadius(r, length int) int {
+	if r > (length-1)/2 {
+		r = (length - 1) / 2
+	}
+	if r < 0 {
+		r = 0
+	}
+	return r
+}
+
+func boxBlurParallel(d Direction, length int, src, dst *image.RGBA, r int, pr *progress) {
+	parallel(length, func(start, end int) {
+		pr.each(start, end, func(start, end int) {
+			switch d {
+			case dirX:
+				BoxBlurHorizontal(src, dst, src.Bounds().Min.Y+start, src.Bounds().Min.Y+end, r)
+			case dirY:
+				BoxBlurTotal(src, dst, src.Bounds().Min.X+start, src.Bounds().Min.X+end, r)
+			}
+		})
+	})
 }
 
-func boxBlurParallel(d Direction, length int, src, dst *image.RGBA, r int) {
+// parallel splits [0, length) into one chunk per CPU and calls fn for each
+// chunk in its own goroutine.
+func parallel(length int, fn func(start, end int)) {
 	procs := runtime.NumCPU()
 	ps := length / procs
+	if ps < 1 {
+		ps = 1
+	}
 
 	var wg sync.WaitGroup
 	for length > 0 {
@@ -55,12 +148,7 @@ func boxBlurParallel(d Direction, length int, src, dst *image.RGBA, r int) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			switch d {
-			case dirX:
-				BoxBlurHorizontal(src, dst, src.Bounds().Min.Y+start, src.Bounds().Min.Y+end, r)
-			case dirY:
-				BoxBlurTotal(src, dst, src.Bounds().Min.X+start, src.Bounds().Min.X+end, r)
-			}
+			fn(start, end)
 		}()
 	}
 
@@ -70,6 +158,7 @@ func boxBlurParallel(d Direction, length int, src, dst *image.RGBA, r int) {
 func BoxBlurHorizontal(src, dst *image.RGBA, start, end, r int) {
 	fr := float64(r)
 	iarr := 1.0 / (fr + fr + 1.0)
+	w := src.Bounds().Dx()
 
 	for i := start; i < end; i++ {
 		ti := src.Bounds().Min.X
@@ -115,7 +204,7 @@ func BoxBlurHorizontal(src, dst *image.RGBA, start, end, r int) {
 			ti++
 		}
 
-		for j := r + 1; j < src.Bounds().Max.X-r; j++ {
+		for j := r + 1; j < w-r; j++ {
 			ripos := src.PixOffset(ri, i)
 			ri++
 
@@ -136,7 +225,7 @@ func BoxBlurHorizontal(src, dst *image.RGBA, start, end, r int) {
 			ti++
 		}
 
-		for j := src.Bounds().Max.X - r; j < src.Bounds().Max.X; j++ {
+		for j := w - r; j < w; j++ {
 			pos := src.PixOffset(li, i)
 			li++
 
@@ -159,6 +248,7 @@ func BoxBlurHorizontal(src, dst *image.RGBA, start, end, r int) {
 func BoxBlurTotal(src, dst *image.RGBA, start, end, r int) {
 	fr := float64(r)
 	iarr := 1.0 / (fr + fr + 1.0)
+	h := src.Bounds().Dy()
 
 	for i := start; i < end; i++ {
 		ti := src.Bounds().Min.Y
@@ -204,7 +294,7 @@ func BoxBlurTotal(src, dst *image.RGBA, start, end, r int) {
 			ti++
 		}
 
-		for j := r + 1; j < src.Bounds().Max.Y-r; j++ {
+		for j := r + 1; j < h-r; j++ {
 			ripos := src.PixOffset(i, ri)
 			ri++
 
@@ -225,7 +315,7 @@ func BoxBlurTotal(src, dst *image.RGBA, start, end, r int) {
 			ti++
 		}
 
-		for j := src.Bounds().Max.Y - r; j < src.Bounds().Max.Y; j++ {
+		for j := h - r; j < h; j++ {
 			pos := src.PixOffset(i, li)
 			li++
 
@@ -8,6 +8,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/anthonynsimon/bild/blur"
@@ -86,6 +87,113 @@ func BenchmarkGaussianBlurUsingGoroutine(b *testing.B) {
 	}
 }
 
+func BenchmarkGaussianBlurNRGBA(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.GaussianBlurNRGBA(img, r)
+	}
+}
+
+func BenchmarkNormalizedBlur(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.NormalizedBlur(img, r, nil)
+	}
+}
+
+func BenchmarkPixelate(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.Pixelate(img, 16, r)
+	}
+}
+
+func BenchmarkFrostedGlass(b *testing.B) {
+	panel := image.Rect(64, 64, 448, 320)
+	opts := &song2.FrostedGlassOptions{Radius: 12, CornerRadius: 16, Tint: color.NRGBA{255, 255, 255, 64}, Noise: 0.04}
+	for n := 0; n < b.N; n++ {
+		song2.FrostedGlass(img, panel, opts)
+	}
+}
+
+func BenchmarkLensBlur(b *testing.B) {
+	opts := &song2.LensBlurOptions{Radius: 2 * r, Blades: 6, Threshold: 0.8, Boost: 4}
+	for n := 0; n < b.N; n++ {
+		song2.LensBlur(img, opts)
+	}
+}
+
+func BenchmarkMedianFilter(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.MedianFilter(img, int(r))
+	}
+}
+
+func BenchmarkDilate(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.Dilate(img, int(r), int(r))
+	}
+}
+
+func BenchmarkKuwahara(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.Kuwahara(img, int(r))
+	}
+}
+
+func BenchmarkAnisotropicKuwahara(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.AnisotropicKuwahara(img, int(r), 8, 1)
+	}
+}
+
+func BenchmarkBoxBlur(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.BoxBlur(img, int(r), int(r))
+	}
+}
+
+func BenchmarkConvolveSeparable(b *testing.B) {
+	k := []float64{0.0625, 0.25, 0.375, 0.25, 0.0625}
+	for n := 0; n < b.N; n++ {
+		song2.ConvolveSeparable(img, k, k)
+	}
+}
+
+func BenchmarkConvolve(b *testing.B) {
+	k := song2.NewKernel([][]float64{
+		{-2, -1, 0},
+		{-1, 1, 1},
+		{0, 1, 2},
+	})
+	for n := 0; n < b.N; n++ {
+		song2.Convolve(img, k, nil)
+	}
+}
+
+func BenchmarkMotionBlur(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.MotionBlur(img, 30, 4*r)
+	}
+}
+
+func BenchmarkZoomBlur(b *testing.B) {
+	c := img.Bounds().Max.Div(2)
+	for n := 0; n < b.N; n++ {
+		song2.ZoomBlur(img, c, 0.2, 16)
+	}
+}
+
+func BenchmarkSpinBlur(b *testing.B) {
+	c := img.Bounds().Max.Div(2)
+	for n := 0; n < b.N; n++ {
+		song2.SpinBlur(img, c, 10, 16)
+	}
+}
+
+func BenchmarkFastBilateralFilter(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		song2.FastBilateralFilter(img, r, 25)
+	}
+}
+
 // SimpleGaussianBlur implements super naive Gaussian Blur
 func SimpleGaussianBlur(src image.Image, r float64) *image.RGBA {
 	clone := song2.CloneToRGBA(src)
@@ -256,3 +364,178 @@ func song2WithoutGoroutine(src image.Image, r float64) *image.RGBA {
 
 	return dst
 }
+
+func TestRankFilter(t *testing.T) {
+	src := noiseImage(image.Rect(3, 5, 26, 21))
+	for _, radius := range []int{1, 2, 4} {
+		for _, rank := range []float64{0, 0.25, 0.5, 0.9, 1} {
+			got := song2.RankFilter(src, radius, rank)
+			want := naiveRank(src, radius, rank)
+			if d := firstDiff(got, want); d != "" {
+				t.Errorf("RankFilter(r=%d, rank=%v): %s", radius, rank, d)
+			}
+		}
+	}
+}
+
+func TestDilateErode(t *testing.T) {
+	src := noiseImage(image.Rect(3, 5, 26, 21))
+	for _, r := range [][2]int{{0, 0}, {1, 1}, {2, 0}, {0, 3}, {3, 1}, {30, 30}} {
+		if d := firstDiff(song2.Dilate(src, r[0], r[1]), naiveMorph(src, r[0], r[1], true)); d != "" {
+			t.Errorf("Dilate(%d, %d): %s", r[0], r[1], d)
+		}
+		if d := firstDiff(song2.Erode(src, r[0], r[1]), naiveMorph(src, r[0], r[1], false)); d != "" {
+			t.Errorf("Erode(%d, %d): %s", r[0], r[1], d)
+		}
+	}
+
+	empty := image.NewRGBA(image.Rect(0, 0, 0, 0))
+	for _, f := range []func(image.Image, int, int) *image.RGBA{song2.Dilate, song2.Erode, song2.Open, song2.Close} {
+		if got := f(empty, 2, 2); !got.Bounds().Empty() {
+			t.Errorf("got bounds %v for an empty image", got.Bounds())
+		}
+	}
+}
+
+// noiseImage returns an image of b filled with pseudo random pixels.
+func noiseImage(b image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(b)
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+	}
+	return img
+}
+
+// window calls fn with every pixel of the (2*rx+1) by (2*ry+1) rectangle
+// around x, y, repeating the edge pixels outside of src.
+func window(src *image.RGBA, x, y, rx, ry int, fn func(c color.RGBA)) {
+	b := src.Bounds()
+	clamp := func(v, lo, hi int) int {
+		if v < lo {
+			return lo
+		}
+		if v > hi {
+			return hi
+		}
+		return v
+	}
+	for dy := -ry; dy <= ry; dy++ {
+		for dx := -rx; dx <= rx; dx++ {
+			fn(src.RGBAAt(clamp(x+dx, b.Min.X, b.Max.X-1), clamp(y+dy, b.Min.Y, b.Max.Y-1)))
+		}
+	}
+}
+
+func naiveRank(src *image.RGBA, radius int, rank float64) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var chans [4][]int
+			window(src, x, y, radius, radius, func(c color.RGBA) {
+				for i, v := range []uint8{c.R, c.G, c.B, c.A} {
+					chans[i] = append(chans[i], int(v))
+				}
+			})
+			var out [4]uint8
+			for i, vs := range chans {
+				sort.Ints(vs)
+				out[i] = uint8(vs[int(math.Round(rank*float64(len(vs)-1)))])
+			}
+			dst.SetRGBA(x, y, color.RGBA{out[0], out[1], out[2], out[3]})
+		}
+	}
+	return dst
+}
+
+func naiveMorph(src *image.RGBA, rx, ry int, dilate bool) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out := src.RGBAAt(x, y)
+			pick := func(a *uint8, v uint8) {
+				if dilate && v > *a || !dilate && v < *a {
+					*a = v
+				}
+			}
+			window(src, x, y, rx, ry, func(c color.RGBA) {
+				pick(&out.R, c.R)
+				pick(&out.G, c.G)
+				pick(&out.B, c.B)
+				pick(&out.A, c.A)
+			})
+			dst.SetRGBA(x, y, out)
+		}
+	}
+	return dst
+}
+
+// firstDiff describes the first pixel where got and want differ, or returns
+// "" if they are the same.
+func firstDiff(got, want *image.RGBA) string {
+	if got.Bounds() != want.Bounds() {
+		return fmt.Sprintf("bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := got.RGBAAt(x, y), want.RGBAAt(x, y); g != w {
+				return fmt.Sprintf("pixel (%d, %d) is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+	return ""
+}
+
+func TestLensBlurFlat(t *testing.T) {
+	for _, b := range []image.Rectangle{image.Rect(0, 0, 65, 46), image.Rect(-7, 12, 40, 50)} {
+		src := image.NewRGBA(b)
+		for i := 0; i < len(src.Pix); i += 4 {
+			copy(src.Pix[i:], []uint8{90, 140, 200, 255})
+		}
+		for _, opts := range []song2.LensBlurOptions{
+			{Radius: 6},
+			{Radius: 2.5, Blades: 5},
+			{Radius: 6, Blades: 6, Rotation: 15},
+		} {
+			if d := firstDiff(song2.LensBlur(src, &opts), src); d != "" {
+				t.Errorf("LensBlur(%v, %+v) of a flat image: %s", b, opts, d)
+			}
+		}
+	}
+}
+
+func TestMotionBlurFlat(t *testing.T) {
+	src := image.NewRGBA(image.Rect(-3, 4, 37, 29))
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []uint8{90, 140, 200, 255})
+	}
+	for _, tt := range []struct{ angle, length float64 }{{0, 9}, {30, 20.5}, {45, 1e9}, {100, 7}} {
+		if d := firstDiff(song2.MotionBlur(src, tt.angle, tt.length), src); d != "" {
+			t.Errorf("MotionBlur(%v, %v) of a flat image: %s", tt.angle, tt.length, d)
+		}
+	}
+}
+
+func TestInpaintNilMask(t *testing.T) {
+	src := noiseImage(image.Rect(2, 3, 19, 14))
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 255
+	}
+	if d := firstDiff(song2.CloneToRGBA(song2.Inpaint(src, nil)), src); d != "" {
+		t.Errorf("Inpaint(src, nil): %s", d)
+	}
+}
+
+func TestAnisotropicKuwaharaAlpha(t *testing.T) {
+	src := noiseImage(image.Rect(0, 0, 24, 18))
+	want := song2.AnisotropicKuwahara(src, 2, 8, 0.1)
+	for _, alpha := range []float64{0, -1, math.NaN()} {
+		if d := firstDiff(song2.AnisotropicKuwahara(src, 2, 8, alpha), want); d != "" {
+			t.Errorf("AnisotropicKuwahara(alpha=%v): %s", alpha, d)
+		}
+	}
+}